@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// "4:1", "4/1", "4-for-1", "4 for 1"
+	splitRatioPattern = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*(?::|/|-for-|\s+for\s+)\s*(\d+(?:\.\d+)?)\s*$`)
+)
+
+// parseSplitRatio turns a split ratio like "4:1" (4 new shares for every 1
+// old share) into the factor prices before the split get divided by and
+// volumes get multiplied by. A 1:10 reverse split gives 0.1.
+func parseSplitRatio(ratio string) (float64, error) {
+	matches := splitRatioPattern.FindStringSubmatch(strings.ToLower(ratio))
+	if matches == nil {
+		return 0, fmt.Errorf("invalid split ratio '%s'", ratio)
+	}
+	numerator, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	denominator, err := strconv.ParseFloat(matches[2], 64)
+	if err != nil {
+		return 0, err
+	}
+	if numerator == 0 || denominator == 0 {
+		return 0, fmt.Errorf("invalid split ratio '%s'", ratio)
+	}
+	return numerator / denominator, nil
+}
+
+// getSplitsByTicker returns all known splits for a ticker, oldest first
+func getSplitsByTicker(deps *Dependencies, tickerId uint64) ([]TickerSplit, error) {
+	db := deps.db
+
+	var splits []TickerSplit
+	err := db.Select(&splits, "SELECT * FROM ticker_split WHERE ticker_id=? ORDER BY split_date", tickerId)
+	return splits, err
+}
+
+// getDividendsByTicker returns all known dividends for a ticker, oldest first
+func getDividendsByTicker(deps *Dependencies, tickerId uint64) ([]TickerDividend, error) {
+	db := deps.db
+
+	var dividends []TickerDividend
+	err := db.Select(&dividends, "SELECT * FROM ticker_dividend WHERE ticker_id=? ORDER BY ex_date", tickerId)
+	return dividends, err
+}
+
+// adjustmentEvent is a split or dividend on a given day, whose factor applies
+// to every bar before that day
+type adjustmentEvent struct {
+	date   time.Time
+	factor float64
+}
+
+// priceAdjustmentRange is a span of days [from, until) that all get the same
+// cumulative adjustment factor; a zero from/until means unbounded
+type priceAdjustmentRange struct {
+	from   time.Time
+	until  time.Time
+	factor float64
+}
+
+// adjustmentRanges turns a list of events (oldest first) into the ranges of
+// days between them, each with the product of the factors of every event
+// that happened after it
+func adjustmentRanges(events []adjustmentEvent) []priceAdjustmentRange {
+	ranges := make([]priceAdjustmentRange, 0, len(events)+1)
+
+	factor := 1.0
+	until := time.Time{}
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].factor == 0 {
+			// unknown factor, not applied
+			continue
+		}
+		ranges = append(ranges, priceAdjustmentRange{events[i].date, until, factor})
+		factor *= events[i].factor
+		until = events[i].date
+	}
+	ranges = append(ranges, priceAdjustmentRange{time.Time{}, until, factor})
+	return ranges
+}
+
+// updateAdjustmentRanges runs the update once per range, adding the ticker
+// and date bounds to it; setArgs are the arguments for the SET clause,
+// given the cumulative factor for that range
+func updateAdjustmentRanges(deps *Dependencies, tickerId uint64, ranges []priceAdjustmentRange, update string, setArgs func(float64) []interface{}, onlyMissing string) error {
+	db := deps.db
+
+	for _, r := range ranges {
+		query := update + " WHERE ticker_id=?"
+		args := append(setArgs(r.factor), tickerId)
+		if !r.from.IsZero() {
+			query += " AND price_date >= ?"
+			args = append(args, r.from.Format("2006-01-02"))
+		}
+		if !r.until.IsZero() {
+			query += " AND price_date < ?"
+			args = append(args, r.until.Format("2006-01-02"))
+		}
+		if onlyMissing != "" {
+			query += " AND " + onlyMissing + " IS NULL"
+		}
+		if _, err := db.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adjustTickerDailies recomputes the split-adjusted series and total-return
+// factor in ticker_daily for a ticker. With onlyMissing it just fills in bars
+// that haven't been adjusted yet, which is all that's needed unless a new
+// split or dividend showed up. It returns the earliest bar that is new or
+// whose prices changed since it was last adjusted (zero if none), since a
+// bar corrected in place needs the indicators after it redone too.
+func adjustTickerDailies(deps *Dependencies, tickerId uint64, onlyMissing bool) (time.Time, error) {
+	db := deps.db
+
+	var adjustedFrom sql.NullTime
+	err := db.QueryRowx("SELECT MIN(price_datetime) FROM ticker_daily WHERE ticker_id=? AND adj_close_price IS NULL", tickerId).Scan(&adjustedFrom)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	splits, err := getSplitsByTicker(deps, tickerId)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// splits recorded before we stored factors
+	for i := range splits {
+		if splits[i].SplitFactor.Valid {
+			continue
+		}
+		factor, err := parseSplitRatio(splits[i].SplitRatio)
+		if err != nil {
+			continue
+		}
+		splits[i].SplitFactor = sql.NullFloat64{Valid: true, Float64: factor}
+		db.Exec("UPDATE ticker_split SET split_factor=? WHERE ticker_split_id=?", factor, splits[i].TickerSplitId)
+	}
+
+	splitEvents := make([]adjustmentEvent, 0, len(splits))
+	for _, split := range splits {
+		// a zero factor is unknown and gets skipped
+		splitEvents = append(splitEvents, adjustmentEvent{split.SplitDate, split.SplitFactor.Float64})
+	}
+	missing := ""
+	if onlyMissing {
+		missing = "adj_close_price"
+	}
+	err = updateAdjustmentRanges(deps, tickerId, adjustmentRanges(splitEvents),
+		"UPDATE ticker_daily SET adj_open_price=open_price/?, adj_high_price=high_price/?, adj_low_price=low_price/?, adj_close_price=close_price/?, adj_volume=ROUND(volume*?)",
+		func(factor float64) []interface{} { return []interface{}{factor, factor, factor, factor, factor} },
+		missing)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// total-return factor goes on top of the split-adjusted close, so it has
+	// to be computed after it
+	dividendEvents, err := getDividendAdjustments(deps, tickerId)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if onlyMissing {
+		missing = "total_return_factor"
+	}
+	err = updateAdjustmentRanges(deps, tickerId, adjustmentRanges(dividendEvents),
+		"UPDATE ticker_daily SET total_return_factor=?",
+		func(factor float64) []interface{} { return []interface{}{factor} },
+		missing)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return adjustedFrom.Time, nil
+}
+
+// getDividendAdjustments computes the total-return factor for each dividend,
+// oldest first, from the close the day before the ex-date
+func getDividendAdjustments(deps *Dependencies, tickerId uint64) ([]adjustmentEvent, error) {
+	db := deps.db
+	sublog := deps.logger
+
+	dividends, err := getDividendsByTicker(deps, tickerId)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]adjustmentEvent, 0, len(dividends))
+	for _, dividend := range dividends {
+		var prevDay TickerDaily
+		err := db.QueryRowx("SELECT price_datetime, close_price, adj_close_price FROM ticker_daily WHERE ticker_id=? AND price_date < ? ORDER BY price_date DESC LIMIT 1", tickerId, dividend.ExDate.Format("2006-01-02")).StructScan(&prevDay)
+		factor, ok := dividendFactor(dividend, prevDay)
+		if err != nil || !ok {
+			// no usable close before the ex-date, can't apply this one
+			sublog.Debug().Uint64("ticker_id", tickerId).Str("ex_date", dividend.ExDate.Format("2006-01-02")).Msg("skipping dividend in total-return factor")
+			continue
+		}
+		events = append(events, adjustmentEvent{dividend.ExDate, factor})
+	}
+	return events, nil
+}
+
+// dividendFactor is 1 - amount / the close the day before the ex-date. The
+// amount is what was paid per share at the time, so it's taken against the
+// close as it was then, not one adjusted for splits that came later.
+func dividendFactor(dividend TickerDividend, prevDay TickerDaily) (float64, bool) {
+	if dividend.Amount <= 0 || prevDay.ClosePrice <= dividend.Amount {
+		return 0, false
+	}
+	return 1 - dividend.Amount/prevDay.ClosePrice, true
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestParseSplitRatio(t *testing.T) {
+	tests := []struct {
+		ratio   string
+		want    float64
+		wantErr bool
+	}{
+		{"2:1", 2, false},
+		{"4/1", 4, false},
+		{"1:10", 0.1, false},
+		{"3-for-2", 1.5, false},
+		{" 3 For 2 ", 1.5, false},
+		{"1.5:1", 1.5, false},
+		{"", 0, true},
+		{"2", 0, true},
+		{"two:one", 0, true},
+		{"2:0", 0, true},
+		{"0:1", 0, true},
+		{"2:1:1", 0, true},
+		{"-2:1", 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseSplitRatio(test.ratio)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("%q: got %v, %v; want %v, error %v", test.ratio, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+// a split after the ex-date halves the adjusted close, but not the dividend
+// that was paid against the close at the time
+func TestDividendFactor(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   float64
+		prevDay  TickerDaily
+		want     float64
+		wantSkip bool
+	}{
+		{"no split", 1, TickerDaily{ClosePrice: 100, AdjClosePrice: sql.NullFloat64{Valid: true, Float64: 100}}, 0.99, false},
+		{"2:1 split after the ex-date", 1, TickerDaily{ClosePrice: 100, AdjClosePrice: sql.NullFloat64{Valid: true, Float64: 50}}, 0.99, false},
+		{"not adjusted yet", 1, TickerDaily{ClosePrice: 100}, 0.99, false},
+		{"no close", 1, TickerDaily{}, 0, true},
+		{"bigger than the close", 120, TickerDaily{ClosePrice: 100}, 0, true},
+		{"no amount", 0, TickerDaily{ClosePrice: 100}, 0, true},
+	}
+	for _, test := range tests {
+		got, ok := dividendFactor(TickerDividend{Amount: test.amount}, test.prevDay)
+		if ok == test.wantSkip || got != test.want {
+			t.Errorf("%s: got %v, %v; want %v", test.name, got, ok, test.want)
+		}
+	}
+}
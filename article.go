@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/jmoiron/sqlx"
 )
 
 type Article struct {
@@ -16,25 +18,153 @@ type Article struct {
 	Body               string       `db:"body"`
 	ArticleURL         string       `db:"article_url"`
 	ImageURL           string       `db:"image_url"`
+	// plain-text derivatives of Body, filled in by setTextFields
+	BodyText       string `db:"body_text"`
+	Summary        string `db:"summary"`
+	WordCount      int    `db:"word_count"`
+	ReadingMinutes int    `db:"reading_minutes"`
+	// dedup keys, filled in by saveArticle
+	TitleKey       string    `db:"title_key"`
+	CanonicalURL   string    `db:"canonical_url"`
+	BodySignature  string    `db:"body_signature"`
+	CreateDatetime time.Time `db:"create_datetime"`
+	UpdateDatetime time.Time `db:"update_datetime"`
+}
+
+// Source is where articles come from: a wire service, publisher or feed.
+// SourceString is how the provider names it and how we find it again;
+// SourceName is what we show, and can be renamed.
+type Source struct {
+	SourceId          uint64       `db:"source_id"`
+	SourceString      string       `db:"source_string"`
+	SourceName        string       `db:"source_name"`
+	Status            string       `db:"status"`
+	FirstSeenDatetime sql.NullTime `db:"first_seen_datetime"`
+	CreateDatetime    time.Time    `db:"create_datetime"`
+	UpdateDatetime    time.Time    `db:"update_datetime"`
+}
+
+const (
+	sourceApproved      = "approved"
+	sourcePendingReview = "pending_review"
+	sourceBlocked       = "blocked"
+)
+
+// ArticleRevision is an article as it was before the publisher updated it
+type ArticleRevision struct {
+	ArticleRevisionId  uint64       `db:"article_revision_id"`
+	ArticleId          uint64       `db:"article_id"`
+	PubUpdatedDatetime sql.NullTime `db:"pubupdated_datetime"`
+	Title              string       `db:"title"`
+	Body               string       `db:"body"`
+	ArticleURL         string       `db:"article_url"`
+	ImageURL           string       `db:"image_url"`
 	CreateDatetime     time.Time    `db:"create_datetime"`
 	UpdateDatetime     time.Time    `db:"update_datetime"`
 }
 
+// ArticleAlias is another source's copy of an article we already have; its
+// external id points at the canonical article instead of being stored again
+type ArticleAlias struct {
+	ArticleAliasId uint64    `db:"article_alias_id"`
+	ArticleId      uint64    `db:"article_id"`
+	SourceId       uint64    `db:"source_id"`
+	ExternalId     string    `db:"external_id"`
+	ArticleURL     string    `db:"article_url"`
+	MatchedOn      string    `db:"matched_on"`
+	CreateDatetime time.Time `db:"create_datetime"`
+	UpdateDatetime time.Time `db:"update_datetime"`
+}
+
 type ArticleTicker struct {
-	ArticleTickerId uint64    `db:"article_ticker_id"`
-	ArticleId       uint64    `db:"article_id"`
-	TickerSymbol    string    `db:"ticker_symbol"`
-	TickerId        uint64    `db:"ticker_id"`
-	CreateDatetime  time.Time `db:"create_datetime"`
-	UpdateDatetime  time.Time `db:"update_datetime"`
+	ArticleTickerId uint64  `db:"article_ticker_id"`
+	ArticleId       uint64  `db:"article_id"`
+	TickerSymbol    string  `db:"ticker_symbol"`
+	TickerId        uint64  `db:"ticker_id"`
+	Confidence      float64 `db:"confidence"`
+	// set by scoreArticleSentiment
+	SentimentScore sql.NullFloat64 `db:"sentiment_score"`
+	PositiveWords  int             `db:"positive_words"`
+	NegativeWords  int             `db:"negative_words"`
+	CreateDatetime time.Time       `db:"create_datetime"`
+	UpdateDatetime time.Time       `db:"update_datetime"`
+}
+
+const sourceColumns = "source_id, source_string, source_name, status, first_seen_datetime, create_datetime, update_datetime"
+
+func getSource(deps *Dependencies, sourceString string) (Source, error) {
+	db := deps.db
+
+	var source Source
+	err := db.QueryRowx("SELECT "+sourceColumns+" FROM source WHERE source_string=?", sourceString).StructScan(&source)
+	return source, err
+}
+
+func getSourceById(deps *Dependencies, sourceId uint64) (Source, error) {
+	db := deps.db
+
+	var source Source
+	err := db.QueryRowx("SELECT "+sourceColumns+" FROM source WHERE source_id=?", sourceId).StructScan(&source)
+	return source, err
+}
+
+// getSources lists sources, just those with the given status if it's set,
+// newest first
+func getSources(deps *Dependencies, status string) ([]Source, error) {
+	db := deps.db
+
+	var sources []Source
+	var err error
+	if status == "" {
+		err = db.Select(&sources, "SELECT "+sourceColumns+" FROM source ORDER BY source_id DESC")
+	} else {
+		err = db.Select(&sources, "SELECT "+sourceColumns+" FROM source WHERE status=? ORDER BY source_id DESC", status)
+	}
+	return sources, err
+}
+
+// getOrCreateSource returns a source, registering it for review if it's one
+// we haven't seen before. Its articles are stored either way, unless
+// someone has blocked it.
+func getOrCreateSource(deps *Dependencies, sourceString string) (Source, error) {
+	db := deps.db
+	sublog := deps.logger
+
+	source, err := getSource(deps, sourceString)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return source, err
+	}
+
+	_, err = db.Exec("INSERT INTO source SET source_string=?, source_name=?, status=?, first_seen_datetime=now()", sourceString, sourceString, sourcePendingReview)
+	if err != nil {
+		// someone else may have just registered it
+		if source, getErr := getSource(deps, sourceString); getErr == nil {
+			return source, nil
+		}
+		return source, err
+	}
+	sublog.Info().Str("source", sourceString).Msg("registered new source {source}, pending review")
+	return getSource(deps, sourceString)
 }
 
-func getSourceId(deps *Dependencies, source string) (uint64, error) {
+func (s *Source) updateStatus(deps *Dependencies, status string) error {
 	db := deps.db
 
-	var sourceId uint64
-	err := db.QueryRowx("SELECT source_id FROM source WHERE source_string=?", source).Scan(&sourceId)
-	return sourceId, err
+	_, err := db.Exec("UPDATE source SET status=? WHERE source_id=?", status, s.SourceId)
+	if err == nil {
+		s.Status = status
+	}
+	return err
+}
+
+func (s *Source) rename(deps *Dependencies, name string) error {
+	db := deps.db
+
+	_, err := db.Exec("UPDATE source SET source_name=? WHERE source_id=?", name, s.SourceId)
+	if err == nil {
+		s.SourceName = name
+	}
+	return err
 }
 
 func (a *Article) getArticleById(deps *Dependencies) error {
@@ -48,8 +178,9 @@ func getArticleByExternalId(deps *Dependencies, externalId string) (uint64, erro
 	db := deps.db
 	sublog := deps.logger
 
+	// either the article itself or an alias of it
 	var articleId uint64
-	err := db.QueryRowx("SELECT article_id FROM article WHERE external_id=?", externalId).Scan(&articleId)
+	err := db.QueryRowx("SELECT article_id FROM article WHERE external_id=? UNION SELECT article_id FROM article_alias WHERE external_id=? LIMIT 1", externalId, externalId).Scan(&articleId)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, nil
@@ -60,13 +191,38 @@ func getArticleByExternalId(deps *Dependencies, externalId string) (uint64, erro
 	return articleId, err
 }
 
+// getArticleBySourceExternalId finds what a source's own id for a story
+// was saved as: its article, or the article it turned out to duplicate
+// (aliased true). Ids are only unique within a source, so another source's
+// story with the same id is never a match; saveArticle catches those if
+// they are the same story.
+func getArticleBySourceExternalId(deps *Dependencies, sourceId uint64, externalId string) (uint64, bool, error) {
+	db := deps.db
+	sublog := deps.logger
+
+	var found struct {
+		ArticleId uint64 `db:"article_id"`
+		Aliased   bool   `db:"aliased"`
+	}
+	err := db.QueryRowx(`SELECT article_id, FALSE AS aliased FROM article WHERE source_id=? AND external_id=?
+		UNION ALL SELECT article_id, TRUE AS aliased FROM article_alias WHERE source_id=? AND external_id=?
+		ORDER BY aliased LIMIT 1`, sourceId, externalId, sourceId, externalId).StructScan(&found)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		sublog.Warn().Err(err).Str("table_name", "article").Msg("Failed to check for existing record")
+	}
+	return found.ArticleId, found.Aliased, err
+}
+
 func (a *Article) createArticle(deps *Dependencies) error {
 	db := deps.db
 	sublog := deps.logger
 
-	var insert = "INSERT INTO article SET source_id=?, external_id=?, published_datetime=?, pubupdated_datetime=?, title=?, body=?, article_url=?, image_url=?"
+	var insert = "INSERT INTO article SET source_id=?, external_id=?, published_datetime=?, pubupdated_datetime=?, title=?, body=?, article_url=?, image_url=?, body_text=?, summary=?, word_count=?, reading_minutes=?, title_key=?, canonical_url=?, body_signature=?"
 
-	res, err := db.Exec(insert, a.SourceId, a.ExternalId, a.PublishedDatetime, a.PubUpdatedDatetime, a.Title, a.Body, a.ArticleURL, a.ImageURL)
+	res, err := db.Exec(insert, a.SourceId, a.ExternalId, a.PublishedDatetime, a.PubUpdatedDatetime, a.Title, a.Body, a.ArticleURL, a.ImageURL, a.BodyText, a.Summary, a.WordCount, a.ReadingMinutes, a.TitleKey, a.CanonicalURL, a.BodySignature)
 	if err != nil {
 		sublog.Fatal().Err(err).Str("table_name", "article").Msg("failed on INSERT")
 	}
@@ -89,9 +245,9 @@ func (at *ArticleTicker) createArticleTicker(deps *Dependencies) error {
 	db := deps.db
 	sublog := deps.logger
 
-	var insert = "INSERT INTO article_ticker SET article_id=?, ticker_symbol=?, ticker_id=?"
+	var insert = "INSERT INTO article_ticker SET article_id=?, ticker_symbol=?, ticker_id=?, confidence=?"
 
-	res, err := db.Exec(insert, at.ArticleId, at.TickerSymbol, at.TickerId)
+	res, err := db.Exec(insert, at.ArticleId, at.TickerSymbol, at.TickerId, at.Confidence)
 	if err != nil {
 		sublog.Fatal().Err(err).
 			Str("table_name", "article_ticker").
@@ -106,3 +262,338 @@ func (at *ArticleTicker) createArticleTicker(deps *Dependencies) error {
 	at.ArticleTickerId = uint64(recordId)
 	return at.getArticleTickerById(deps)
 }
+
+// saveArticle is how every source stores a new article. If it's the same
+// story as one we already have from any source (same canonical URL, same
+// title around the same time, or a near-identical body) it's recorded as an
+// alias of that one and a is loaded with the canonical article instead.
+// A new article's images are mirrored to our bucket and it's indexed for
+// search. Returns true if it was a duplicate.
+func (a *Article) saveArticle(deps *Dependencies) (bool, error) {
+	sublog := deps.logger
+
+	a.setTextFields()
+	a.TitleKey = normalizeArticleTitle(a.Title)
+	a.CanonicalURL = canonicalArticleURL(a.ArticleURL)
+	signature := articleBodySignature(a.Body)
+	a.BodySignature = encodeSignature(signature)
+
+	canonicalId, matchedOn, err := a.findDuplicate(deps, signature)
+	if err != nil {
+		sublog.Warn().Err(err).Str("table_name", "article").Msg("failed to check for duplicate article")
+	}
+	if canonicalId != 0 {
+		alias := ArticleAlias{ArticleId: canonicalId, SourceId: a.SourceId, ExternalId: a.ExternalId, ArticleURL: a.ArticleURL, MatchedOn: matchedOn}
+		if err := alias.createArticleAlias(deps); err != nil {
+			return false, err
+		}
+		sublog.Info().Uint64("article_id", canonicalId).Str("external_id", a.ExternalId).Str("matched_on", matchedOn).Msg("article {external_id} is a duplicate of {article_id} by {matched_on}")
+		*a = Article{ArticleId: canonicalId}
+		return true, a.getArticleById(deps)
+	}
+
+	err = a.createArticle(deps)
+	if err != nil {
+		return false, err
+	}
+	err = createArticleSignatureBands(deps.db, a.ArticleId, signatureBands(signature))
+	if err != nil {
+		return false, err
+	}
+	if err := mirrorArticleImages(deps, *sublog, a); err != nil {
+		sublog.Warn().Err(err).Uint64("article_id", a.ArticleId).Msg("failed to mirror images for article {article_id}")
+	}
+	if err := indexArticle(deps, a); err != nil {
+		sublog.Warn().Err(err).Uint64("article_id", a.ArticleId).Msg("failed to index article {article_id} for search")
+	}
+	return false, nil
+}
+
+// findDuplicate looks for an article from around the same time that's the
+// same story, returning its id and what matched
+func (a *Article) findDuplicate(deps *Dependencies, signature []uint64) (uint64, string, error) {
+	db := deps.db
+
+	published := time.Now()
+	if a.PublishedDatetime.Valid {
+		published = a.PublishedDatetime.Time
+	}
+	from, until := published.AddDate(0, 0, -dedupWindowDays), published.AddDate(0, 0, dedupWindowDays)
+
+	var articleId uint64
+	if a.CanonicalURL != "" {
+		err := db.QueryRowx("SELECT article_id FROM article WHERE canonical_url=? ORDER BY article_id LIMIT 1", a.CanonicalURL).Scan(&articleId)
+		if err == nil {
+			return articleId, "url", nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return 0, "", err
+		}
+	}
+
+	if len(a.TitleKey) >= dedupMinTitleLength {
+		err := db.QueryRowx("SELECT article_id FROM article WHERE title_key=? AND published_datetime BETWEEN ? AND ? ORDER BY article_id LIMIT 1", a.TitleKey, from, until).Scan(&articleId)
+		if err == nil {
+			return articleId, "title", nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return 0, "", err
+		}
+
+		// a reworded headline, among the articles whose titles share the
+		// most indexed words with this one
+		if terms := searchTerms(a.TitleKey); len(terms) > 0 {
+			query, args, err := sqlx.In(`SELECT article.article_id, article.title_key
+				FROM article_term
+				JOIN article ON (article.article_id=article_term.article_id)
+				WHERE article_term.term IN (?) AND article_term.title_count > 0 AND article.published_datetime BETWEEN ? AND ?
+				GROUP BY article.article_id, article.title_key
+				ORDER BY COUNT(*) DESC, article.article_id
+				LIMIT ?`, terms, from, until, dedupMaxTitleCandidates)
+			if err != nil {
+				return 0, "", err
+			}
+			var candidates []struct {
+				ArticleId uint64 `db:"article_id"`
+				TitleKey  string `db:"title_key"`
+			}
+			err = db.Select(&candidates, db.Rebind(query), args...)
+			if err != nil {
+				return 0, "", err
+			}
+			best, bestSimilarity := uint64(0), 0.0
+			for _, candidate := range candidates {
+				similarity := titleSimilarity(a.TitleKey, candidate.TitleKey)
+				if similarity >= dedupTitleSimilarity && similarity > bestSimilarity {
+					best, bestSimilarity = candidate.ArticleId, similarity
+				}
+			}
+			if best != 0 {
+				return best, "title", nil
+			}
+		}
+	}
+
+	if signature == nil {
+		return 0, "", nil
+	}
+	query, args, err := sqlx.In(`SELECT DISTINCT article.article_id, article.body_signature
+		FROM article_signature_band
+		JOIN article ON (article.article_id=article_signature_band.article_id)
+		WHERE article_signature_band.band IN (?) AND article.published_datetime BETWEEN ? AND ?
+		LIMIT ?`, signatureBands(signature), from, until, dedupMaxBandCandidates)
+	if err != nil {
+		return 0, "", err
+	}
+	var candidates []struct {
+		ArticleId     uint64 `db:"article_id"`
+		BodySignature string `db:"body_signature"`
+	}
+	err = db.Select(&candidates, db.Rebind(query), args...)
+	if err != nil {
+		return 0, "", err
+	}
+	best, bestSimilarity := uint64(0), 0.0
+	for _, candidate := range candidates {
+		similarity := signatureSimilarity(signature, decodeSignature(candidate.BodySignature))
+		if similarity >= dedupBodySimilarity && similarity > bestSimilarity {
+			best, bestSimilarity = candidate.ArticleId, similarity
+		}
+	}
+	if best != 0 {
+		return best, "body", nil
+	}
+	return 0, "", nil
+}
+
+func createArticleSignatureBands(db sqlx.Execer, articleId uint64, bands []uint64) error {
+	for _, band := range bands {
+		_, err := db.Exec("INSERT IGNORE INTO article_signature_band SET article_id=?, band=?", articleId, band)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateBodySignature replaces an article's body signature and the bands
+// it's found by, together so it's never left without them
+func (a *Article) updateBodySignature(deps *Dependencies, signature []uint64) error {
+	db := deps.db
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	a.BodySignature = encodeSignature(signature)
+	if _, err := tx.Exec("UPDATE article SET body_signature=? WHERE article_id=?", a.BodySignature, a.ArticleId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM article_signature_band WHERE article_id=?", a.ArticleId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := createArticleSignatureBands(tx, a.ArticleId, signatureBands(signature)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (aa *ArticleAlias) createArticleAlias(deps *Dependencies) error {
+	db := deps.db
+
+	var insert = "INSERT INTO article_alias SET article_id=?, source_id=?, external_id=?, article_url=?, matched_on=?"
+	res, err := db.Exec(insert, aa.ArticleId, aa.SourceId, aa.ExternalId, aa.ArticleURL, aa.MatchedOn)
+	if err != nil {
+		return err
+	}
+	recordId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	aa.ArticleAliasId = uint64(recordId)
+	return nil
+}
+
+// linkArticleTicker links a ticker to an article, or if it already is (a
+// duplicate arriving for the same ticker, or a ticker it mentions that
+// found the article itself) raises the confidence if this one is surer
+func linkArticleTicker(deps *Dependencies, articleId uint64, tickerId uint64, tickerSymbol string, confidence float64) error {
+	db := deps.db
+
+	articleTicker := ArticleTicker{}
+	err := db.QueryRowx("SELECT * FROM article_ticker WHERE article_id=? AND ticker_id=?", articleId, tickerId).StructScan(&articleTicker)
+	if err == nil {
+		if confidence <= articleTicker.Confidence {
+			return nil
+		}
+		_, err = db.Exec("UPDATE article_ticker SET confidence=? WHERE article_ticker_id=?", confidence, articleTicker.ArticleTickerId)
+		return err
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	articleTicker = ArticleTicker{ArticleId: articleId, TickerSymbol: tickerSymbol, TickerId: tickerId, Confidence: confidence}
+	return articleTicker.createArticleTicker(deps)
+}
+
+func getArticlesWithoutText(deps *Dependencies, limit int) ([]Article, error) {
+	db := deps.db
+
+	var articles []Article
+	// every article with a title or body gets some summary
+	err := db.Select(&articles, "SELECT article_id, title, body FROM article WHERE (summary='' OR summary IS NULL) AND (title != '' OR body != '') LIMIT ?", limit)
+	return articles, err
+}
+
+// getArticlesAfter pages through every article by id, with just what the
+// search index and body signatures need
+func getArticlesAfter(deps *Dependencies, articleId uint64, limit int) ([]Article, error) {
+	db := deps.db
+
+	var articles []Article
+	err := db.Select(&articles, "SELECT article_id, title, body, body_text, body_signature FROM article WHERE article_id > ? ORDER BY article_id LIMIT ?", articleId, limit)
+	return articles, err
+}
+
+func (a *Article) updateTextFields(deps *Dependencies) error {
+	db := deps.db
+
+	var update = "UPDATE article SET body_text=?, summary=?, word_count=?, reading_minutes=? WHERE article_id=?"
+	_, err := db.Exec(update, a.BodyText, a.Summary, a.WordCount, a.ReadingMinutes, a.ArticleId)
+	return err
+}
+
+// refreshArticle updates a stored article from a newer version of it: if
+// the provider's updated timestamp is after ours, the current title, body
+// and URLs are kept as a revision and replaced. An empty body or image in
+// the update (some providers only ever send a headline) leaves ours alone.
+// Only the source the article came from can refresh it, not an alias.
+// Returns true if it was refreshed.
+func (a *Article) refreshArticle(deps *Dependencies, updated Article) (bool, error) {
+	db := deps.db
+	sublog := deps.logger
+
+	if a.ExternalId != updated.ExternalId || a.SourceId != updated.SourceId {
+		return false, nil
+	}
+	if !updated.PubUpdatedDatetime.Valid || (a.PubUpdatedDatetime.Valid && !updated.PubUpdatedDatetime.Time.After(a.PubUpdatedDatetime.Time)) {
+		return false, nil
+	}
+
+	// the revision, the update and the new bands go in together, so a
+	// failure part way doesn't leave a revision for a change that didn't
+	// happen or an article without its bands
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	revision := ArticleRevision{ArticleId: a.ArticleId, PubUpdatedDatetime: a.PubUpdatedDatetime, Title: a.Title, Body: a.Body, ArticleURL: a.ArticleURL, ImageURL: a.ImageURL}
+	if err := revision.createArticleRevision(tx); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	a.PubUpdatedDatetime = updated.PubUpdatedDatetime
+	if updated.Title != "" {
+		a.Title = updated.Title
+	}
+	if updated.Body != "" {
+		a.Body = updated.Body
+	}
+	if updated.ArticleURL != "" {
+		a.ArticleURL = updated.ArticleURL
+	}
+	if updated.ImageURL != "" {
+		a.ImageURL = updated.ImageURL
+	}
+	a.setTextFields()
+	a.TitleKey = normalizeArticleTitle(a.Title)
+	a.CanonicalURL = canonicalArticleURL(a.ArticleURL)
+	signature := articleBodySignature(a.Body)
+	a.BodySignature = encodeSignature(signature)
+
+	var update = "UPDATE article SET pubupdated_datetime=?, title=?, body=?, article_url=?, image_url=?, body_text=?, summary=?, word_count=?, reading_minutes=?, title_key=?, canonical_url=?, body_signature=? WHERE article_id=?"
+	_, err = tx.Exec(update, a.PubUpdatedDatetime, a.Title, a.Body, a.ArticleURL, a.ImageURL, a.BodyText, a.Summary, a.WordCount, a.ReadingMinutes, a.TitleKey, a.CanonicalURL, a.BodySignature, a.ArticleId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	_, err = tx.Exec("DELETE FROM article_signature_band WHERE article_id=?", a.ArticleId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	err = createArticleSignatureBands(tx, a.ArticleId, signatureBands(signature))
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	sublog.Info().Uint64("article_id", a.ArticleId).Str("external_id", a.ExternalId).Msg("refreshed article {article_id} from a newer version")
+	// images we already have for it aren't fetched again
+	if err := mirrorArticleImages(deps, *sublog, a); err != nil {
+		sublog.Warn().Err(err).Uint64("article_id", a.ArticleId).Msg("failed to mirror images for article {article_id}")
+	}
+	if err := indexArticle(deps, a); err != nil {
+		sublog.Warn().Err(err).Uint64("article_id", a.ArticleId).Msg("failed to index article {article_id} for search")
+	}
+	return true, nil
+}
+
+func (ar *ArticleRevision) createArticleRevision(db sqlx.Execer) error {
+	var insert = "INSERT INTO article_revision SET article_id=?, pubupdated_datetime=?, title=?, body=?, article_url=?, image_url=?"
+	res, err := db.Exec(insert, ar.ArticleId, ar.PubUpdatedDatetime, ar.Title, ar.Body, ar.ArticleURL, ar.ImageURL)
+	if err != nil {
+		return err
+	}
+	recordId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	ar.ArticleRevisionId = uint64(recordId)
+	return nil
+}
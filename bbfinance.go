@@ -26,9 +26,20 @@ func (f *Financials) createOrUpdate(deps *Dependencies) error {
 	db := deps.db
 	sublog := deps.logger
 
+	// if the company restated this figure, keep the original as a revision
+	// before it gets replaced below
+	var previousValue float64
+	err := db.QueryRowx("SELECT chart_value FROM financials WHERE ticker_id=? AND form_name=? AND form_term_name=? AND chart_name=? AND chart_datetime=?", f.TickerId, f.FormName, f.FormTermName, f.ChartName, f.ChartDatetime).Scan(&previousValue)
+	if err == nil && isRestatement(previousValue, f.ChartValue) {
+		revision := FinancialsRevision{0, f.TickerId, f.FormName, f.FormTermName, f.ChartName, f.ChartDatetime, previousValue, f.ChartValue, time.Now(), time.Now()}
+		if err := revision.create(deps); err != nil {
+			sublog.Warn().Err(err).Str("table_name", "financials_revision").Msg("failed to record restatement")
+		}
+	}
+
 	var insertOrUpdate = "INSERT INTO financials SET ticker_id=?, form_name=?, form_term_name=?, chart_name=?, chart_datetime=?, chart_type=?, is_percentage=?, chart_value=?, create_datetime=now() ON DUPLICATE KEY UPDATE chart_value=?, update_datetime=now()"
 
-	_, err := db.Exec(insertOrUpdate, f.TickerId, f.FormName, f.FormTermName, f.ChartName, f.ChartDatetime, f.ChartType, f.IsPercentage, f.ChartValue, f.ChartValue)
+	_, err = db.Exec(insertOrUpdate, f.TickerId, f.FormName, f.FormTermName, f.ChartName, f.ChartDatetime, f.ChartType, f.IsPercentage, f.ChartValue, f.ChartValue)
 	if err != nil {
 		sublog.Fatal().Err(err).
 			Str("table_name", "financials").
@@ -164,11 +175,16 @@ func loadBBStories(deps *Dependencies, ticker Ticker) error {
 
 	var err error
 
-	sourceId, err := getSourceId(deps, "Bloomberg")
+	source, err := getOrCreateSource(deps, "Bloomberg")
 	if err != nil {
-		sublog.Error().Err(err).Msg("unknown source, skipping BB stories")
+		sublog.Error().Err(err).Msg("failed to get source, skipping BB stories")
 		return err
 	}
+	if source.Status == sourceBlocked {
+		sublog.Info().Msg("source is blocked, skipping BB stories")
+		return nil
+	}
+	sourceId := source.SourceId
 
 	autoCompleteResponse := bbfinance.BBAutoCompleteResponse{}
 	autoCompleteResponse, err = bbfinance.BBAutoComplete(&sublog, apiKey, apiHost, ticker.TickerSymbol)
@@ -188,21 +204,43 @@ func loadBBStories(deps *Dependencies, ticker Ticker) error {
 		}
 		sublog.Info().Msg("pulling stories for {symbol}")
 		for _, story := range storiesListResponse.Stories {
+			article := Article{
+				SourceId:           sourceId,
+				ExternalId:         story.InternalId,
+				PublishedDatetime:  sql.NullTime{Valid: true, Time: time.Unix(story.Published, 0)},
+				PubUpdatedDatetime: sql.NullTime{Valid: true, Time: time.Unix(story.UpdatedAt, 0)},
+				Title:              story.Title,
+				ArticleURL:         story.LongURL,
+				ImageURL:           story.ThumbnailImage,
+			}
+
 			if existingId, err := getArticleByExternalId(deps, story.InternalId); err != nil {
 				sublog.Info().Err(err).Str("existing_id", story.InternalId).Msg("failed to check for existing article by external id")
 			} else if existingId != 0 {
-				// already have this story saved
-				continue
+				// already have this story saved, but it may have been updated since
+				existing := Article{ArticleId: existingId}
+				if err := existing.getArticleById(deps); err != nil {
+					sublog.Warn().Err(err).Msg("failed to load existing story")
+					continue
+				}
+				refreshed, err := existing.refreshArticle(deps, article)
+				if err != nil {
+					sublog.Warn().Err(err).Msg("failed to refresh updated story")
+				}
+				if refreshed {
+					err = linkArticleTickers(deps, sublog, existing, ticker)
+					if err != nil {
+						sublog.Warn().Err(err).Msg("failed to write ticker(s) for updated story")
+					}
+				}
 			} else {
-				article := Article{0, sourceId, story.InternalId, sql.NullTime{Valid: true, Time: time.Unix(story.Published, 0)}, sql.NullTime{Valid: true, Time: time.Unix(story.UpdatedAt, 0)}, story.Title, "", story.LongURL, story.ThumbnailImage, time.Now(), time.Now()}
-
-				err = article.createArticle(deps)
+				_, err = article.saveArticle(deps)
 				if err != nil {
 					sublog.Warn().Err(err).Msg("failed to write new story")
+					continue
 				}
 
-				articleTicker := ArticleTicker{0, article.ArticleId, ticker.TickerSymbol, ticker.TickerId, time.Now(), time.Now()}
-				err = articleTicker.createArticleTicker(deps)
+				err = linkArticleTickers(deps, sublog, article, ticker)
 				if err != nil {
 					sublog.Warn().Err(err).Msg("failed to write ticker(s) for new story")
 				}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"sync"
+	"time"
+	_ "time/tzdata" // exchange timezones shouldn't depend on the host having tzdata
+)
+
+// TradingCalendar knows when an exchange is in session: its timezone,
+// regular hours, holidays and early closes. Times of day are offsets from
+// local midnight.
+type TradingCalendar struct {
+	Name        string
+	Location    *time.Location
+	Open        time.Duration
+	Close       time.Duration
+	EarlyClose  time.Duration
+	holidays    func(year int) map[string]bool
+	earlyCloses func(year int) map[string]bool
+}
+
+var (
+	newYork, _ = time.LoadLocation("America/New_York")
+
+	usCalendar = &TradingCalendar{
+		Name:        "US",
+		Location:    newYork,
+		Open:        9*time.Hour + 30*time.Minute,
+		Close:       16 * time.Hour,
+		EarlyClose:  13 * time.Hour,
+		holidays:    cachedByYear(usMarketHolidays),
+		earlyCloses: cachedByYear(usMarketEarlyCloses),
+	}
+
+	// weekdays only, US hours, for any exchange we don't have a calendar for
+	defaultCalendar = &TradingCalendar{
+		Name:     "default",
+		Location: newYork,
+		Open:     9*time.Hour + 30*time.Minute,
+		Close:    16 * time.Hour,
+	}
+
+	tradingCalendarsByMic = map[string]*TradingCalendar{
+		"XNYS": usCalendar, // NYSE
+		"XNAS": usCalendar, // NASDAQ
+		"XASE": usCalendar, // NYSE American
+		"ARCX": usCalendar, // NYSE Arca
+		"BATS": usCalendar, // Cboe BZX
+	}
+
+	tradingCalendarCache   = make(map[uint64]*TradingCalendar)
+	tradingCalendarCacheMu sync.Mutex
+)
+
+// getTradingCalendar returns the calendar for an exchange, falling back to
+// the default calendar if the exchange is unknown
+func getTradingCalendar(deps *Dependencies, exchangeId uint64) *TradingCalendar {
+	tradingCalendarCacheMu.Lock()
+	defer tradingCalendarCacheMu.Unlock()
+
+	if calendar, ok := tradingCalendarCache[exchangeId]; ok {
+		return calendar
+	}
+
+	exchange := Exchange{ExchangeId: exchangeId}
+	if err := exchange.getById(deps); err != nil {
+		// not cached, so a failed lookup doesn't stick for the life of
+		// the process
+		deps.logger.Warn().Err(err).Uint64("exchange_id", exchangeId).Msg("failed to get exchange for trading calendar, using the default")
+		return defaultCalendar
+	}
+	calendar := defaultCalendar
+	if known, ok := tradingCalendarsByMic[exchange.ExchangeMic]; ok {
+		calendar = known
+	}
+	tradingCalendarCache[exchangeId] = calendar
+	return calendar
+}
+
+func (tc *TradingCalendar) isTradingDay(date time.Time) bool {
+	date = date.In(tc.Location)
+	if date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+		return false
+	}
+	if tc.holidays != nil && tc.holidays(date.Year())[date.Format("2006-01-02")] {
+		return false
+	}
+	return true
+}
+
+// knowsHolidays is false for calendars that only know weekends, where any
+// weekday could be a holiday we'd never hear about
+func (tc *TradingCalendar) knowsHolidays() bool {
+	return tc.holidays != nil
+}
+
+func (tc *TradingCalendar) isEarlyClose(date time.Time) bool {
+	date = date.In(tc.Location)
+	return tc.earlyCloses != nil && tc.earlyCloses(date.Year())[date.Format("2006-01-02")]
+}
+
+// session returns the open and close times for the trading day containing
+// date, or ok=false if the exchange isn't open that day
+func (tc *TradingCalendar) session(date time.Time) (open time.Time, close time.Time, ok bool) {
+	if !tc.isTradingDay(date) {
+		return time.Time{}, time.Time{}, false
+	}
+	date = date.In(tc.Location)
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, tc.Location)
+	closeAt := tc.Close
+	if tc.isEarlyClose(date) {
+		closeAt = tc.EarlyClose
+	}
+	return midnight.Add(tc.Open), midnight.Add(closeAt), true
+}
+
+// isOpen is true if the exchange is in its regular session at that moment
+func (tc *TradingCalendar) isOpen(when time.Time) bool {
+	open, close, ok := tc.session(when)
+	return ok && !when.Before(open) && when.Before(close)
+}
+
+// lastSessionClose returns the close of the most recent session that had
+// already ended at that moment
+func (tc *TradingCalendar) lastSessionClose(when time.Time) time.Time {
+	day := when.In(tc.Location)
+	// no exchange closes for more than a few days in a row
+	for i := 0; i < 14; i++ {
+		if _, close, ok := tc.session(day); ok && !close.After(when) {
+			return close
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return when.AddDate(0, 0, -14)
+}
+
+// newEODAvailableSince is the moment the latest end-of-day bar became
+// available, allowing for the provider to publish a while after the close;
+// anything fetched after this already has everything there is
+func (tc *TradingCalendar) newEODAvailableSince(now time.Time) time.Time {
+	return tc.lastSessionClose(now.Add(-eodsPostCloseDelay * time.Minute)).Add(eodsPostCloseDelay * time.Minute)
+}
+
+// financialsAvailableSince is when the financials behind an earnings report
+// dated reportDate should be out: the close that day (the regular close if
+// it wasn't a trading day) plus earningsFinancialsDelay, which catches
+// reports that come after the close. reportDate is a plain date.
+func (tc *TradingCalendar) financialsAvailableSince(reportDate time.Time) time.Time {
+	midnight := time.Date(reportDate.Year(), reportDate.Month(), reportDate.Day(), 0, 0, 0, 0, tc.Location)
+	_, close, ok := tc.session(midnight.Add(12 * time.Hour))
+	if !ok {
+		close = midnight.Add(tc.Close)
+	}
+	return close.Add(earningsFinancialsDelay * time.Minute)
+}
+
+// cachedByYear wraps a per-year date set so each year is only worked out
+// once; isTradingDay and friends ask for it on every call
+func cachedByYear(build func(year int) map[string]bool) func(year int) map[string]bool {
+	var mu sync.Mutex
+	byYear := make(map[int]map[string]bool)
+	return func(year int) map[string]bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		dates, ok := byYear[year]
+		if !ok {
+			dates = build(year)
+			byYear[year] = dates
+		}
+		return dates
+	}
+}
+
+// NYSE holiday rules: a holiday on Saturday is observed the Friday before,
+// on Sunday the Monday after (except New Year's Day, which isn't moved back
+// into the prior year)
+func usMarketHolidays(year int) map[string]bool {
+	holidays := make(map[string]bool)
+	add := func(date time.Time) { holidays[date.Format("2006-01-02")] = true }
+	observed := func(date time.Time) time.Time {
+		switch date.Weekday() {
+		case time.Saturday:
+			return date.AddDate(0, 0, -1)
+		case time.Sunday:
+			return date.AddDate(0, 0, 1)
+		}
+		return date
+	}
+
+	newYears := time.Date(year, time.January, 1, 0, 0, 0, 0, newYork)
+	if newYears.Weekday() != time.Saturday {
+		add(observed(newYears))
+	}
+	add(nthWeekday(year, time.January, time.Monday, 3))  // Martin Luther King Jr. Day
+	add(nthWeekday(year, time.February, time.Monday, 3)) // Washington's Birthday
+	add(easterSunday(year).AddDate(0, 0, -2))            // Good Friday
+	add(lastWeekday(year, time.May, time.Monday))        // Memorial Day
+	if year >= 2022 {
+		add(observed(time.Date(year, time.June, 19, 0, 0, 0, 0, newYork))) // Juneteenth
+	}
+	add(observed(time.Date(year, time.July, 4, 0, 0, 0, 0, newYork)))
+	add(nthWeekday(year, time.September, time.Monday, 1))  // Labor Day
+	add(nthWeekday(year, time.November, time.Thursday, 4)) // Thanksgiving
+	add(observed(time.Date(year, time.December, 25, 0, 0, 0, 0, newYork)))
+
+	return holidays
+}
+
+// the NYSE closes at 1pm on July 3rd and Christmas Eve when they fall on a
+// weekday that isn't itself a holiday, and the day after Thanksgiving
+func usMarketEarlyCloses(year int) map[string]bool {
+	earlyCloses := make(map[string]bool)
+	holidays := usMarketHolidays(year)
+
+	for _, date := range []time.Time{
+		time.Date(year, time.July, 3, 0, 0, 0, 0, newYork),
+		time.Date(year, time.December, 24, 0, 0, 0, 0, newYork),
+	} {
+		if date.Weekday() != time.Saturday && date.Weekday() != time.Sunday && !holidays[date.Format("2006-01-02")] {
+			earlyCloses[date.Format("2006-01-02")] = true
+		}
+	}
+	earlyCloses[nthWeekday(year, time.November, time.Thursday, 4).AddDate(0, 0, 1).Format("2006-01-02")] = true
+
+	return earlyCloses
+}
+
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	date := time.Date(year, month, 1, 0, 0, 0, 0, newYork)
+	for date.Weekday() != weekday {
+		date = date.AddDate(0, 0, 1)
+	}
+	return date.AddDate(0, 0, 7*(n-1))
+}
+
+func lastWeekday(year int, month time.Month, weekday time.Weekday) time.Time {
+	date := time.Date(year, month+1, 1, 0, 0, 0, 0, newYork).AddDate(0, 0, -1)
+	for date.Weekday() != weekday {
+		date = date.AddDate(0, 0, -1)
+	}
+	return date
+}
+
+// anonymous Gregorian algorithm
+func easterSunday(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := ((h + l - 7*m + 114) % 31) + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, newYork)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runCommand handles the commands given on the command line, as opposed to
+// the default queue processing loop
+func runCommand(deps *Dependencies, command string, args []string) error {
+	switch command {
+	case "scheduler":
+		return runScheduler(deps)
+	case "restatements":
+		return commandRestatements(deps, args)
+	case "articletext":
+		return commandArticleText(deps)
+	case "feeds":
+		return commandFeeds(deps, args)
+	case "sources":
+		return commandSources(deps, args)
+	case "search":
+		return commandSearch(deps, args)
+	case "searchindex":
+		return commandSearchIndex(deps)
+	case "signatures":
+		return commandSignatures(deps)
+	case "searchapi":
+		return commandSearchAPI(deps, args)
+	default:
+		return fmt.Errorf("unknown command (%s)", command)
+	}
+}
+
+// restatements [symbol]
+func commandRestatements(deps *Dependencies, args []string) error {
+	symbol := ""
+	if len(args) > 0 {
+		symbol = args[0]
+	}
+
+	restatements, err := getRestatements(deps, symbol)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SYMBOL\tPERIOD\tFORM\tTERM\tCHART\tOLD VALUE\tNEW VALUE\tDETECTED")
+	for _, r := range restatements {
+		period := ""
+		if r.ChartDatetime.Valid {
+			period = r.ChartDatetime.Time.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%g\t%g\t%s\n", r.TickerSymbol, period, r.FormName, r.FormTermName, r.ChartName, r.OldValue, r.NewValue, r.DetectedDatetime.Format(sqlDateTime))
+	}
+	return w.Flush()
+}
+
+// articletext: fill in the plain-text fields for articles stored before we
+// kept them
+func commandArticleText(deps *Dependencies) error {
+	sublog := deps.logger
+
+	count := 0
+	for {
+		articles, err := getArticlesWithoutText(deps, 500)
+		if err != nil {
+			return err
+		}
+		if len(articles) == 0 {
+			break
+		}
+		filled := 0
+		for _, article := range articles {
+			article.setTextFields()
+			if err := article.updateTextFields(deps); err != nil {
+				return err
+			}
+			if article.Summary != "" {
+				filled++
+			}
+		}
+		if filled == 0 {
+			// what's left has nothing to summarize
+			break
+		}
+		count += filled
+		sublog.Info().Int("count", count).Msg("filled in text for {count} articles")
+	}
+	fmt.Printf("filled in text for %d articles\n", count)
+	return nil
+}
+
+// feeds list
+// feeds add <url> [source] [symbol]
+// feeds read [feed_id ...]
+func commandFeeds(deps *Dependencies, args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		feeds, err := getActiveFeeds(deps)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tURL\tSOURCE\tTICKER_ID\tLAST FETCHED")
+		for _, feed := range feeds {
+			lastFetched := ""
+			if feed.LastFetchedDatetime.Valid {
+				lastFetched = feed.LastFetchedDatetime.Time.Format(sqlDateTime)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", feed.FeedId, feed.FeedURL, feed.SourceString, feed.TickerId, lastFetched)
+		}
+		return w.Flush()
+	case "add":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: feeds add <url> [source] [symbol]")
+		}
+		if err := checkFeedURL(args[1]); err != nil {
+			return err
+		}
+		feed := Feed{FeedURL: args[1]}
+		if len(args) > 2 {
+			feed.SourceString = args[2]
+		}
+		if len(args) > 3 {
+			ticker := Ticker{TickerSymbol: args[3]}
+			if err := ticker.getBySymbol(deps); err != nil {
+				return fmt.Errorf("unknown symbol %s: %w", args[3], err)
+			}
+			feed.TickerId = ticker.TickerId
+		}
+		if err := feed.createFeed(deps); err != nil {
+			return err
+		}
+		fmt.Printf("added feed %d\n", feed.FeedId)
+		return nil
+	case "read":
+		// reads right away, whenever they were last read
+		var feeds []Feed
+		var err error
+		if len(args) > 1 {
+			feedIds := make([]uint64, 0, len(args)-1)
+			for _, arg := range args[1:] {
+				feedId, err := strconv.ParseUint(arg, 10, 64)
+				if err != nil {
+					return fmt.Errorf("bad feed id %s", arg)
+				}
+				feedIds = append(feedIds, feedId)
+			}
+			feeds, err = getFeedsByIds(deps, feedIds)
+		} else {
+			feeds, err = getActiveFeeds(deps)
+		}
+		if err != nil {
+			return err
+		}
+		for _, feed := range feeds {
+			feedlog := deps.logger.With().Str("feed_url", feed.FeedURL).Logger()
+			if err := perform_feed(deps, feedlog, feed, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown feeds command (%s)", args[0])
+	}
+}
+
+// sources list [pending_review|approved|blocked|all]
+// sources approve <source_id>
+// sources rename <source_id> <name>
+// sources block <source_id>
+func commandSources(deps *Dependencies, args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	if args[0] == "list" {
+		// the ones waiting for someone to look at them, unless asked otherwise
+		status := sourcePendingReview
+		if len(args) > 1 {
+			status = args[1]
+		}
+		if status == "all" {
+			status = ""
+		}
+		sources, err := getSources(deps, status)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSOURCE\tNAME\tSTATUS\tFIRST SEEN")
+		for _, source := range sources {
+			firstSeen := ""
+			if source.FirstSeenDatetime.Valid {
+				firstSeen = source.FirstSeenDatetime.Time.Format(sqlDateTime)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", source.SourceId, source.SourceString, source.SourceName, source.Status, firstSeen)
+		}
+		return w.Flush()
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("usage: sources %s <source_id>", args[0])
+	}
+	sourceId, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad source id %s", args[1])
+	}
+	source, err := getSourceById(deps, sourceId)
+	if err != nil {
+		return fmt.Errorf("unknown source %d: %w", sourceId, err)
+	}
+
+	switch args[0] {
+	case "approve":
+		err = source.updateStatus(deps, sourceApproved)
+	case "block":
+		// stops new articles from it, what we already have stays
+		err = source.updateStatus(deps, sourceBlocked)
+	case "rename":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: sources rename <source_id> <name>")
+		}
+		err = source.rename(deps, strings.Join(args[2:], " "))
+	default:
+		return fmt.Errorf("unknown sources command (%s)", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("source %d (%s) is now %s, %s\n", source.SourceId, source.SourceString, source.SourceName, source.Status)
+	return nil
+}
+
+// search [-ticker SYMBOL] [-from YYYY-MM-DD] [-until YYYY-MM-DD] [-limit N] <words or "quoted phrases">
+func commandSearch(deps *Dependencies, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	ticker := flags.String("ticker", "", "only articles linked to this symbol")
+	from := flags.String("from", "", "only articles published on or after this date")
+	until := flags.String("until", "", "only articles published before this date")
+	limit := flags.Int("limit", searchDefaultLimit, "most results to show")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	search := ArticleSearch{Query: strings.Join(flags.Args(), " "), TickerSymbol: strings.ToUpper(*ticker), Limit: *limit}
+	var err error
+	if search.From, err = parseSearchDate(*from); err != nil {
+		return err
+	}
+	if search.Until, err = parseSearchDate(*until); err != nil {
+		return err
+	}
+
+	results, incomplete, err := searchArticles(deps, search)
+	if err != nil {
+		return err
+	}
+	if incomplete {
+		fmt.Fprintln(os.Stderr, "only the best candidates were checked for the phrases, there may be more matches")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPUBLISHED\tSCORE\tTITLE\tURL")
+	for _, result := range results {
+		published := ""
+		if result.PublishedDatetime.Valid {
+			published = result.PublishedDatetime.Time.Format(sqlDateTime)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%g\t%s\t%s\n", result.ArticleId, published, result.Score, result.Title, result.ArticleURL)
+	}
+	return w.Flush()
+}
+
+// searchindex: (re)build the search index for every article, for those
+// stored before we kept one
+func commandSearchIndex(deps *Dependencies) error {
+	sublog := deps.logger
+
+	var lastId uint64
+	count := 0
+	for {
+		articles, err := getArticlesAfter(deps, lastId, 500)
+		if err != nil {
+			return err
+		}
+		if len(articles) == 0 {
+			break
+		}
+		for i := range articles {
+			if err := indexArticle(deps, &articles[i]); err != nil {
+				return err
+			}
+			lastId = articles[i].ArticleId
+		}
+		count += len(articles)
+		sublog.Info().Int("count", count).Msg("indexed {count} articles")
+	}
+	fmt.Printf("indexed %d articles\n", count)
+	return nil
+}
+
+// signatures: recompute every article's body signature, needed whenever
+// the text it's taken from changes, like when script and style contents
+// stopped counting as article text
+func commandSignatures(deps *Dependencies) error {
+	sublog := deps.logger
+
+	var lastId uint64
+	count, changed := 0, 0
+	for {
+		articles, err := getArticlesAfter(deps, lastId, 500)
+		if err != nil {
+			return err
+		}
+		if len(articles) == 0 {
+			break
+		}
+		for i := range articles {
+			lastId = articles[i].ArticleId
+			signature := articleBodySignature(articles[i].Body)
+			if encodeSignature(signature) == articles[i].BodySignature {
+				continue
+			}
+			if err := articles[i].updateBodySignature(deps, signature); err != nil {
+				return err
+			}
+			changed++
+		}
+		count += len(articles)
+		sublog.Info().Int("count", count).Int("changed", changed).Msg("checked {count} article signatures, {changed} changed")
+	}
+	fmt.Printf("checked %d article signatures, %d changed\n", count, changed)
+	return nil
+}
+
+// searchapi [address]: serve article search over HTTP, see searchHandler
+func commandSearchAPI(deps *Dependencies, args []string) error {
+	sublog := deps.logger
+
+	address := searchAPIAddress
+	if len(args) > 0 {
+		address = args[0]
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", searchHandler(deps))
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	sublog.Info().Str("address", address).Msg("serving article search on {address}")
+	return server.ListenAndServe()
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"html"
+	"math"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+)
+
+const (
+	articleSummaryLength      = 300 // characters, at most, in an article summary
+	articleSummaryMinWords    = 8   // shorter paragraphs (bylines, datelines) aren't used as the summary
+	articleReadingWordsPerMin = 230
+	maxContentDepth           = 64 // content nested deeper than this is dropped
+)
+
+var (
+	// tags we'll pass through into article bodies, anything else is dropped
+	// but its text kept
+	allowedContentTags = map[string]bool{
+		"p": true, "br": true, "hr": true, "div": true, "span": true,
+		"b": true, "strong": true, "i": true, "em": true, "u": true, "sup": true, "sub": true,
+		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+		"ul": true, "ol": true, "li": true, "blockquote": true, "pre": true, "code": true,
+		"table": true, "thead": true, "tbody": true, "tr": true, "th": true, "td": true,
+		"figure": true, "figcaption": true,
+	}
+
+	// tags with no closing tag
+	voidContentTags = map[string]bool{"br": true, "hr": true}
+
+	// tags that start a new paragraph when pulling the text out of a body
+	paragraphContentTags = map[string]bool{
+		"p": true, "br": true, "div": true, "li": true, "blockquote": true, "pre": true, "tr": true,
+		"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "figcaption": true, "hr": true,
+	}
+
+	// tags whose content is dropped along with them
+	droppedContentTags = map[string]bool{
+		"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+		"noscript": true, "template": true, "svg": true, "math": true, "form": true,
+	}
+)
+
+// contentBuilder writes article HTML from pieces of untrusted content: text
+// is always escaped, only allowlisted tags are written, and the only
+// attributes are an href or src that passed safeContentURL
+type contentBuilder struct {
+	strings.Builder
+}
+
+func (cb *contentBuilder) text(text string) {
+	cb.WriteString(html.EscapeString(text))
+}
+
+// escapedText is for text that may already have entities in it, like what
+// the providers send: they're decoded first so they aren't escaped twice
+func (cb *contentBuilder) escapedText(text string) {
+	cb.text(html.UnescapeString(text))
+}
+
+// open writes an allowlisted tag; returns false if it wasn't written
+func (cb *contentBuilder) open(tag string) bool {
+	if !allowedContentTags[tag] {
+		return false
+	}
+	cb.WriteString("<" + tag + ">")
+	return true
+}
+
+func (cb *contentBuilder) close(tag string) {
+	if allowedContentTags[tag] && !voidContentTags[tag] {
+		cb.WriteString("</" + tag + ">")
+	}
+}
+
+func (cb *contentBuilder) image(src string) {
+	if safe, ok := safeContentURL(src); ok {
+		cb.WriteString(`<img src="` + html.EscapeString(safe) + `">`)
+	}
+}
+
+func (cb *contentBuilder) link(href, text string) {
+	if !cb.openLink(href) {
+		cb.text(text)
+		return
+	}
+	cb.text(text)
+	cb.WriteString("</a>")
+}
+
+// openLink writes an <a> if href is safe; returns false if it wasn't written
+func (cb *contentBuilder) openLink(href string) bool {
+	safe, ok := safeContentURL(href)
+	if !ok {
+		return false
+	}
+	cb.WriteString(`<a href="` + html.EscapeString(safe) + `">`)
+	return true
+}
+
+// safeContentURL only lets through absolute http and https URLs (and
+// protocol-relative ones, as https), so nothing like javascript: or data:
+// ends up in an href or src
+func safeContentURL(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if relativeProtocolUrl.MatchString(raw) {
+		raw = "https:" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return "", false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		return parsed.String(), true
+	}
+	return "", false
+}
+
+// sanitizeHTML rebuilds HTML from elsewhere (feed entries and the like)
+// through contentBuilder, so only what followContent would write survives,
+// with every tag it opens properly closed
+func sanitizeHTML(raw string) string {
+	var builder contentBuilder
+	open := make([]string, 0)
+	closeTo := func(tag string) {
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i] != tag {
+				continue
+			}
+			for j := len(open) - 1; j >= i; j-- {
+				builder.WriteString("</" + open[j] + ">")
+			}
+			open = open[:i]
+			return
+		}
+	}
+
+	skipping := ""
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(raw))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == xhtml.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		tag := strings.ToLower(token.Data)
+		if skipping != "" {
+			if tokenType == xhtml.EndTagToken && tag == skipping {
+				skipping = ""
+			}
+			continue
+		}
+
+		switch tokenType {
+		case xhtml.TextToken:
+			builder.text(token.Data)
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			switch {
+			case droppedContentTags[tag]:
+				if tokenType == xhtml.StartTagToken {
+					skipping = tag
+				}
+			case tag == "img":
+				builder.image(tokenAttr(token, "src"))
+			case tag == "a":
+				if tokenType == xhtml.StartTagToken && builder.openLink(tokenAttr(token, "href")) {
+					open = append(open, tag)
+				}
+			default:
+				if !builder.open(tag) || voidContentTags[tag] {
+					continue
+				}
+				if tokenType == xhtml.SelfClosingTagToken {
+					builder.close(tag)
+				} else {
+					open = append(open, tag)
+				}
+			}
+		case xhtml.EndTagToken:
+			closeTo(tag)
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		builder.WriteString("</" + open[i] + ">")
+	}
+	return builder.String()
+}
+
+func tokenAttr(token xhtml.Token, name string) string {
+	for _, attr := range token.Attr {
+		if strings.ToLower(attr.Key) == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// articleParagraphs pulls the text out of an article body, which may be HTML,
+// as a list of paragraphs with their whitespace collapsed
+func articleParagraphs(body string) []string {
+	paragraphs := make([]string, 0)
+	var paragraph strings.Builder
+	endParagraph := func() {
+		if text := strings.Join(strings.Fields(paragraph.String()), " "); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+		paragraph.Reset()
+	}
+
+	skipping := ""
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case xhtml.ErrorToken:
+			endParagraph()
+			return paragraphs
+		case xhtml.TextToken:
+			if skipping == "" {
+				paragraph.Write(tokenizer.Text())
+			}
+		case xhtml.StartTagToken, xhtml.EndTagToken, xhtml.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if droppedContentTags[tag] {
+				if tokenType == xhtml.StartTagToken && skipping == "" {
+					skipping = tag
+				} else if tokenType == xhtml.EndTagToken && skipping == tag {
+					skipping = ""
+				}
+				continue
+			}
+			if paragraphContentTags[tag] {
+				endParagraph()
+			} else {
+				// keep words on either side of a tag apart
+				paragraph.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// articleText is the text of an article body, all on one line
+func articleText(body string) string {
+	return strings.Join(articleParagraphs(body), " ")
+}
+
+// setTextFields fills in the plain-text derivatives of an article's body:
+// the text itself, a summary from the first real paragraph (or the title,
+// for stories we only have a headline for), a word count and reading time
+func (a *Article) setTextFields() {
+	paragraphs := articleParagraphs(a.Body)
+	a.BodyText = strings.Join(paragraphs, "\n\n")
+
+	a.Summary = ""
+	for _, paragraph := range paragraphs {
+		if len(strings.Fields(paragraph)) >= articleSummaryMinWords {
+			a.Summary = truncateWords(paragraph, articleSummaryLength)
+			break
+		}
+	}
+	if a.Summary == "" && len(paragraphs) > 0 {
+		a.Summary = truncateWords(paragraphs[0], articleSummaryLength)
+	}
+	if a.Summary == "" {
+		a.Summary = truncateWords(strings.TrimSpace(a.Title), articleSummaryLength)
+	}
+
+	a.WordCount = len(strings.Fields(a.BodyText))
+	a.ReadingMinutes = int(math.Ceil(float64(a.WordCount) / articleReadingWordsPerMin))
+}
+
+// truncateWords shortens text to at most length bytes, at a word boundary,
+// with an ellipsis if anything was cut
+func truncateWords(text string, length int) string {
+	if len(text) <= length {
+		return text
+	}
+	cut := strings.LastIndex(text[:length], " ")
+	if cut <= 0 {
+		cut = length
+		// don't split a multibyte character
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return strings.TrimRight(text[:cut], " ,;:-") + "…"
+}
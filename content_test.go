@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateWords(t *testing.T) {
+	tests := []struct {
+		text   string
+		length int
+		want   string
+	}{
+		{"short enough", 20, "short enough"},
+		{"cut at a word boundary, please", 16, "cut at a word…"},
+		{"trailing punctuation, dropped", 22, "trailing punctuation…"},
+		{"überlangeswortohneleerzeichen", 2, "ü…"},
+		{"日本語のテキスト", 7, "日本…"},
+	}
+	for _, test := range tests {
+		got := truncateWords(test.text, test.length)
+		if got != test.want || !utf8.ValidString(got) {
+			t.Errorf("%q/%d: got %q, want %q", test.text, test.length, got, test.want)
+		}
+	}
+}
+
+func TestArticleText(t *testing.T) {
+	body := `<p>Shares rose.</p><script>var tracking = "noise";</script><style>p { color: red }</style><p>Guidance&nbsp;was raised.</p>`
+	if got, want := articleText(body), "Shares rose. Guidance was raised."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
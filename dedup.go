@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	dedupWindowDays         = 3   // days either side of publication we look for the same story
+	dedupMinTitleLength     = 24  // shorter normalized titles are too generic to match on
+	dedupShingleWords       = 5   // words per body shingle
+	dedupMinShingles        = 20  // bodies with fewer shingles don't get a signature
+	dedupSignatureSize      = 32  // minhash values per body signature
+	dedupBandSize           = 4   // signature values per LSH band
+	dedupBodySimilarity     = 0.8 // estimated jaccard similarity that makes two bodies the same story
+	dedupMaxBandCandidates  = 50  // articles compared per new article
+	dedupTitleSimilarity    = 0.8 // share of title words in common that makes two titles the same story
+	dedupMaxTitleCandidates = 50  // articles sharing the most title words compared per new article
+)
+
+// normalizeArticleTitle lowercases a title and reduces it to words, so
+// differences in punctuation, quoting and spacing between sources go away
+func normalizeArticleTitle(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// titleSimilarity is the jaccard similarity of two normalized titles' word
+// sets, so a reworded or re-punctuated headline still scores high
+func titleSimilarity(a, b string) float64 {
+	words := make(map[string]bool)
+	for _, word := range strings.Fields(a) {
+		words[word] = true
+	}
+	if len(words) == 0 {
+		return 0
+	}
+	same, union := 0, len(words)
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(b) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		if words[word] {
+			same++
+		} else {
+			union++
+		}
+	}
+	return float64(same) / float64(union)
+}
+
+// canonicalArticleURL reduces a URL to host and path, without the scheme,
+// www., fragment, trailing slash and tracking parameters
+func canonicalArticleURL(articleURL string) string {
+	if articleURL == "" {
+		return ""
+	}
+	parsed, err := url.Parse(strings.TrimSpace(articleURL))
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	path := strings.TrimRight(parsed.EscapedPath(), "/")
+
+	query := parsed.Query()
+	for key := range query {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, "utm_") || lower == "cmpid" || lower == "srnd" || lower == "ref" {
+			query.Del(key)
+		}
+	}
+	canonical := host + path
+	if encoded := query.Encode(); encoded != "" {
+		canonical += "?" + encoded
+	}
+	return canonical
+}
+
+// articleBodySignature is a minhash signature over word shingles of the
+// body text; two signatures agree at about the rate the bodies' shingle sets
+// overlap. Returns nil for bodies too short to say anything about.
+func articleBodySignature(body string) []uint64 {
+	words := strings.Fields(normalizeArticleTitle(articleText(body)))
+	if len(words) < dedupShingleWords+dedupMinShingles-1 {
+		return nil
+	}
+
+	signature := make([]uint64, dedupSignatureSize)
+	for i := range signature {
+		signature[i] = ^uint64(0)
+	}
+	for i := 0; i+dedupShingleWords <= len(words); i++ {
+		hash := fnv.New64a()
+		hash.Write([]byte(strings.Join(words[i:i+dedupShingleWords], " ")))
+		shingle := hash.Sum64()
+		for j := range signature {
+			if value := mixHash(shingle ^ minhashSeeds[j]); value < signature[j] {
+				signature[j] = value
+			}
+		}
+	}
+	return signature
+}
+
+// signatureSimilarity estimates the jaccard similarity of two bodies from
+// their signatures
+func signatureSimilarity(a, b []uint64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a))
+}
+
+// signatureBands hashes each band of a signature, along with its position;
+// bodies that are near-duplicates almost always share at least one band,
+// so these are what similar articles are looked up by
+func signatureBands(signature []uint64) []uint64 {
+	bands := make([]uint64, 0, len(signature)/dedupBandSize)
+	for i := 0; i+dedupBandSize <= len(signature); i += dedupBandSize {
+		hash := fnv.New64a()
+		fmt.Fprintf(hash, "%d", i)
+		for _, value := range signature[i : i+dedupBandSize] {
+			hash.Write([]byte(strconv.FormatUint(value, 16)))
+		}
+		// stored as a signed BIGINT
+		bands = append(bands, hash.Sum64()>>1)
+	}
+	return bands
+}
+
+func encodeSignature(signature []uint64) string {
+	values := make([]string, len(signature))
+	for i, value := range signature {
+		values[i] = strconv.FormatUint(value, 16)
+	}
+	return strings.Join(values, " ")
+}
+
+func decodeSignature(encoded string) []uint64 {
+	fields := strings.Fields(encoded)
+	signature := make([]uint64, 0, len(fields))
+	for _, field := range fields {
+		value, err := strconv.ParseUint(field, 16, 64)
+		if err != nil {
+			return nil
+		}
+		signature = append(signature, value)
+	}
+	return signature
+}
+
+// splitmix64, used both to spread hashes and to make the minhash seeds
+func mixHash(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+var minhashSeeds = func() []uint64 {
+	seeds := make([]uint64, dedupSignatureSize)
+	for i := range seeds {
+		seeds[i] = mixHash(uint64(i + 1))
+	}
+	return seeds
+}()
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeArticleTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Acme Beats Q3 Estimates", "acme beats q3 estimates"},
+		{"  Acme beats -- Q3 estimates!  ", "acme beats q3 estimates"},
+		{"“Acme” Beats Q3 Estimates…", "acme beats q3 estimates"},
+		{"Acme's 10% jump", "acme s 10 jump"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := normalizeArticleTitle(test.title); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.title, got, test.want)
+		}
+	}
+}
+
+func TestCanonicalArticleURL(t *testing.T) {
+	tests := []struct {
+		articleURL string
+		want       string
+	}{
+		{"https://www.example.com/news/acme-beats/", "example.com/news/acme-beats"},
+		{"http://Example.com/news/acme-beats#comments", "example.com/news/acme-beats"},
+		{"https://example.com/news/acme-beats?utm_source=rss&utm_medium=feed&ref=home", "example.com/news/acme-beats"},
+		{"https://example.com/news?id=42&utm_campaign=x", "example.com/news?id=42"},
+		{"https://example.com/", "example.com"},
+		{"not a url", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := canonicalArticleURL(test.articleURL); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.articleURL, got, test.want)
+		}
+	}
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"acme beats third quarter estimates raises guidance", "acme beats third quarter estimates raises guidance", true},
+		{"acme beats third quarter estimates raises guidance", "acme beats third quarter estimates and raises guidance", true},
+		{"acme beats third quarter estimates raises guidance", "acme misses third quarter estimates cuts guidance", false},
+		{"acme beats third quarter estimates raises guidance", "rivals slump as chip demand fades", false},
+		{"", "acme beats", false},
+	}
+	for _, test := range tests {
+		if got := titleSimilarity(test.a, test.b) >= dedupTitleSimilarity; got != test.same {
+			t.Errorf("%q vs %q: got %v (%.2f), want %v", test.a, test.b, got, titleSimilarity(test.a, test.b), test.same)
+		}
+	}
+}
+
+// articleWords makes a body of distinct words, from word first on
+func articleWords(first, count int) []string {
+	words := make([]string, count)
+	for i := range words {
+		words[i] = fmt.Sprintf("word%d", first+i)
+	}
+	return words
+}
+
+func TestArticleBodySignature(t *testing.T) {
+	body := articleWords(0, 200)
+	signature := articleBodySignature("<p>" + strings.Join(body, " ") + "</p>")
+	if len(signature) != dedupSignatureSize {
+		t.Fatalf("got a signature of %d, want %d", len(signature), dedupSignatureSize)
+	}
+
+	edited := append([]string{}, body...)
+	edited[100] = "changed"
+	unrelated := articleWords(1000, 200)
+	tests := []struct {
+		name string
+		body []string
+		same bool
+	}{
+		{"identical", body, true},
+		{"one word changed", edited, true},
+		{"a paragraph added", append(append([]string{}, body...), articleWords(500, 10)...), true},
+		{"unrelated", unrelated, false},
+		{"half of it", body[:100], false},
+	}
+	for _, test := range tests {
+		other := articleBodySignature("<p>" + strings.Join(test.body, " ") + "</p>")
+		similarity := signatureSimilarity(signature, other)
+		if got := similarity >= dedupBodySimilarity; got != test.same {
+			t.Errorf("%s: got similarity %.2f, want same %v", test.name, similarity, test.same)
+		}
+	}
+
+	if signature := articleBodySignature("<p>too short to say</p>"); signature != nil {
+		t.Errorf("got a signature for a short body: %v", signature)
+	}
+	if similarity := signatureSimilarity(signature, nil); similarity != 0 {
+		t.Errorf("got similarity %.2f against no signature", similarity)
+	}
+}
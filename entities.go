@@ -0,0 +1,273 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	entityCacheMinutes  = 60   // how long the known tickers are reused before reloading
+	entityMinConfidence = 0.5  // matches below this aren't linked
+	entityMaxNameWords  = 6    // longest company name, in words, we try to match
+	entityMentionBonus  = 0.05 // extra confidence for each additional mention
+	entityWeakMax       = 0.45 // most a ticker found only by weak matches can score, below entityMinConfidence
+)
+
+var (
+	// $AAPL
+	cashtagMatch = regexp.MustCompile(`\$([A-Z]{1,5}(?:\.[A-Z])?)\b`)
+	// (NASDAQ: AAPL), NYSE:IBM
+	exchangeSymbolMatch = regexp.MustCompile(`\b(?:NYSE|NASDAQ|Nasdaq|NYSE American|NYSE Arca|AMEX|OTC)(?:[A-Za-z ]*)?:\s*([A-Z]{1,5}(?:\.[A-Z])?)\b`)
+	// a bare all-caps word that might be a symbol
+	bareSymbolMatch = regexp.MustCompile(`\b[A-Z]{2,5}\b`)
+
+	// all-caps words that show up in financial news and happen to be symbols
+	commonCapsWords = map[string]bool{
+		"AI": true, "ALL": true, "AM": true, "AN": true, "ARE": true, "AT": true, "BE": true, "BIG": true, "CAN": true,
+		"CEO": true, "CFO": true, "COO": true, "CTO": true, "EPS": true, "ESG": true, "ETF": true, "EU": true, "EV": true,
+		"FDA": true, "FED": true, "FOR": true, "GDP": true, "GO": true, "IPO": true, "IT": true, "NEW": true, "NOW": true,
+		"ON": true, "ONE": true, "OR": true, "OUT": true, "PM": true, "SEC": true, "SO": true, "TV": true, "UK": true,
+		"UP": true, "US": true, "USA": true, "USD": true, "WTI": true,
+	}
+
+	// words dropped from the end of company names so "Apple Inc." matches
+	// a story that just says Apple
+	companyNameSuffixes = map[string]bool{
+		"inc": true, "incorporated": true, "corp": true, "corporation": true, "co": true, "company": true,
+		"ltd": true, "limited": true, "plc": true, "llc": true, "lp": true, "sa": true, "ag": true, "nv": true,
+		"se": true, "holdings": true, "holding": true, "group": true, "class": true, "a": true, "b": true, "c": true,
+		"the": true, "and": true,
+	}
+
+	entityTickers     *entityIndex
+	entityTickersAt   time.Time
+	entityTickersLock sync.Mutex
+)
+
+type entityTicker struct {
+	TickerId     uint64 `db:"ticker_id"`
+	TickerSymbol string `db:"ticker_symbol"`
+	TickerName   string `db:"ticker_name"`
+	CompanyName  string `db:"company_name"`
+}
+
+// entityIndex is every known ticker by symbol and by normalized company name
+type entityIndex struct {
+	bySymbol map[string]entityTicker
+	byName   map[string]entityTicker
+}
+
+// TickerMention is a ticker an article mentions and how sure we are of it
+type TickerMention struct {
+	Ticker     entityTicker
+	Confidence float64
+	mentions   int
+	strong     bool // found by an explicit symbol or a multi-word name
+}
+
+// getEntityIndex returns the known tickers, reloading them now and then so
+// new tickers start being matched
+func getEntityIndex(deps *Dependencies) (*entityIndex, error) {
+	db := deps.db
+
+	entityTickersLock.Lock()
+	defer entityTickersLock.Unlock()
+
+	if entityTickers != nil && entityTickersAt.Add(entityCacheMinutes*time.Minute).After(time.Now()) {
+		return entityTickers, nil
+	}
+
+	var tickers []entityTicker
+	err := db.Select(&tickers, "SELECT ticker_id, ticker_symbol, ticker_name, company_name FROM ticker")
+	if err != nil {
+		return nil, err
+	}
+
+	entityTickers, entityTickersAt = newEntityIndex(tickers), time.Now()
+	return entityTickers, nil
+}
+
+// newEntityIndex indexes tickers by symbol and by company name, both with
+// corporate suffixes dropped ("Apple") and, if that's a different, longer
+// name, as written ("Apple Inc")
+func newEntityIndex(tickers []entityTicker) *entityIndex {
+	index := &entityIndex{bySymbol: make(map[string]entityTicker, len(tickers)), byName: make(map[string]entityTicker, len(tickers))}
+	for _, ticker := range tickers {
+		index.bySymbol[ticker.TickerSymbol] = ticker
+		for _, name := range []string{ticker.CompanyName, ticker.TickerName} {
+			for _, key := range []string{companyNameKey(name), normalizeArticleTitle(name)} {
+				if len(key) < 4 {
+					continue
+				}
+				if _, taken := index.byName[key]; !taken {
+					index.byName[key] = ticker
+				}
+			}
+		}
+	}
+	return index
+}
+
+// companyNameKey normalizes a company name and drops trailing corporate
+// suffixes
+func companyNameKey(name string) string {
+	words := strings.Fields(normalizeArticleTitle(name))
+	for len(words) > 0 && companyNameSuffixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	for len(words) > 0 && words[0] == "the" {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// mentionsSymbol reports whether text names a symbol the way
+// matchTickerMentions would: as a cashtag, after an exchange, or bare and
+// all-caps as long as it isn't also a common word
+func mentionsSymbol(text, symbol string) bool {
+	for _, re := range []*regexp.Regexp{cashtagMatch, exchangeSymbolMatch} {
+		for _, match := range re.FindAllStringSubmatch(text, -1) {
+			if match[1] == symbol {
+				return true
+			}
+		}
+	}
+	if commonCapsWords[symbol] {
+		return false
+	}
+	for _, word := range bareSymbolMatch.FindAllString(text, -1) {
+		if word == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTickerMentions scans an article's title and text for ticker symbols
+// and company names. Explicit symbols ($AAPL, NASDAQ: AAPL) are near
+// certain and multi-word company names fairly sure. Bare all-caps words and
+// single-word names ("WELL", "Target") are so often just words that alone
+// they stay under entityMinConfidence; they only add to a ticker that's
+// also matched one of the stronger ways. Matches in the title count for
+// more, and every extra mention adds a little.
+func (index *entityIndex) matchTickerMentions(title, text string) map[uint64]*TickerMention {
+	found := make(map[uint64]*TickerMention)
+	add := func(ticker entityTicker, confidence float64, strong bool) {
+		mention, ok := found[ticker.TickerId]
+		if !ok {
+			mention = &TickerMention{Ticker: ticker}
+			found[ticker.TickerId] = mention
+		}
+		mention.mentions++
+		mention.strong = mention.strong || strong
+		if confidence > mention.Confidence {
+			mention.Confidence = confidence
+		}
+	}
+
+	for _, part := range []struct {
+		text    string
+		inTitle bool
+	}{{title, true}, {text, false}} {
+		bonus := 0.0
+		if part.inTitle {
+			bonus = 0.1
+		}
+
+		explicit := make(map[string]bool)
+		for _, re := range []*regexp.Regexp{cashtagMatch, exchangeSymbolMatch} {
+			for _, match := range re.FindAllStringSubmatch(part.text, -1) {
+				if ticker, ok := index.bySymbol[match[1]]; ok {
+					add(ticker, 0.95, true)
+					explicit[match[1]] = true
+				}
+			}
+		}
+		for _, word := range bareSymbolMatch.FindAllString(part.text, -1) {
+			if explicit[word] || commonCapsWords[word] {
+				continue
+			}
+			if ticker, ok := index.bySymbol[word]; ok {
+				add(ticker, 0.3+bonus, false)
+			}
+		}
+
+		words := strings.FieldsFunc(part.text, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for i := range words {
+			for n := entityMaxNameWords; n >= 1; n-- {
+				if i+n > len(words) {
+					continue
+				}
+				// single-word names have to at least be capitalized
+				if n == 1 && !unicode.IsUpper([]rune(words[i])[0]) {
+					continue
+				}
+				key := strings.ToLower(strings.Join(words[i:i+n], " "))
+				if ticker, ok := index.byName[key]; ok {
+					if n == 1 {
+						add(ticker, 0.4+bonus, false)
+					} else {
+						add(ticker, 0.8+bonus, true)
+					}
+					break
+				}
+			}
+		}
+	}
+
+	for _, mention := range found {
+		mention.Confidence += entityMentionBonus * float64(mention.mentions-1)
+		if mention.Confidence > 0.99 {
+			mention.Confidence = 0.99
+		}
+		if !mention.strong && mention.Confidence > entityWeakMax {
+			mention.Confidence = entityWeakMax
+		}
+	}
+	return found
+}
+
+// linkArticleTickers links an article to the ticker whose news it came in
+// with (if any, a zero Ticker for news that isn't about one company), and
+// to every other ticker it mentions with enough confidence
+func linkArticleTickers(deps *Dependencies, sublog zerolog.Logger, article Article, ticker Ticker) error {
+	if ticker.TickerId != 0 {
+		err := linkArticleTicker(deps, article.ArticleId, ticker.TickerId, ticker.TickerSymbol, 1.0)
+		if err != nil {
+			return err
+		}
+	}
+
+	index, err := getEntityIndex(deps)
+	if err != nil {
+		sublog.Warn().Err(err).Msg("failed to load tickers to match in article")
+		return nil
+	}
+	linked := 0
+	for tickerId, mention := range index.matchTickerMentions(article.Title, articleText(article.Body)) {
+		if tickerId == ticker.TickerId || mention.Confidence < entityMinConfidence {
+			continue
+		}
+		err := linkArticleTicker(deps, article.ArticleId, tickerId, mention.Ticker.TickerSymbol, mention.Confidence)
+		if err != nil {
+			return err
+		}
+		linked++
+	}
+	if linked > 0 {
+		sublog.Info().Uint64("article_id", article.ArticleId).Int("linked", linked).Msg("linked article {article_id} to {linked} more tickers")
+	}
+
+	err = scoreArticleSentiment(deps, sublog, article)
+	if err != nil {
+		sublog.Warn().Err(err).Uint64("article_id", article.ArticleId).Msg("failed to score sentiment for article {article_id}")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func testEntityIndex() *entityIndex {
+	return newEntityIndex([]entityTicker{
+		{TickerId: 1, TickerSymbol: "WELL", CompanyName: "Welltower Inc."},
+		{TickerId: 2, TickerSymbol: "LOW", CompanyName: "Lowe's Companies, Inc."},
+		{TickerId: 3, TickerSymbol: "KEY", CompanyName: "KeyCorp"},
+		{TickerId: 4, TickerSymbol: "CAT", CompanyName: "Caterpillar Inc."},
+		{TickerId: 5, TickerSymbol: "TGT", CompanyName: "Target Corporation"},
+		{TickerId: 6, TickerSymbol: "XYZ", CompanyName: "Block, Inc."},
+		{TickerId: 7, TickerSymbol: "MTCH", CompanyName: "Match Group, Inc."},
+		{TickerId: 8, TickerSymbol: "AAPL", CompanyName: "Apple Inc."},
+		{TickerId: 9, TickerSymbol: "GS", CompanyName: "The Goldman Sachs Group, Inc."},
+	})
+}
+
+// linkedSymbols is what linkArticleTickers would link
+func linkedSymbols(index *entityIndex, title, text string) []string {
+	var symbols []string
+	for _, mention := range index.matchTickerMentions(title, text) {
+		if mention.Confidence >= entityMinConfidence {
+			symbols = append(symbols, mention.Ticker.TickerSymbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func TestMatchTickerMentionsFalsePositives(t *testing.T) {
+	index := testEntityIndex()
+	tests := []struct {
+		title string
+		text  string
+	}{
+		{"Stocks Rally as Yields Hit LOW", "Investors were WELL aware that the KEY question was when rates would come down. The CAT is out of the bag."},
+		{"Fed Holds Rates", "The Fed's Target rate is unchanged. Critics said the move could Block growth. Match that with weak payrolls and markets fell."},
+		{"KEY TAKEAWAYS", "LOW LOW LOW prices, WELL WELL WELL. Target Target Target."},
+		{"Apple picking season", "apple orchards and match sticks and a block of cheese."},
+	}
+	for _, test := range tests {
+		if linked := linkedSymbols(index, test.title, test.text); len(linked) > 0 {
+			t.Errorf("%q: linked %v", test.title+" "+test.text, linked)
+		}
+	}
+}
+
+func TestMatchTickerMentions(t *testing.T) {
+	index := testEntityIndex()
+	tests := []struct {
+		title string
+		text  string
+		want  []string
+	}{
+		{"$CAT beats estimates", "", []string{"CAT"}},
+		{"Retail earnings", "Target Corporation (NYSE: TGT) said sales rose.", []string{"TGT"}},
+		{"Apple Inc. reports", "", []string{"AAPL"}},
+		{"Goldman Sachs raises outlook", "Goldman Sachs said GS would hire.", []string{"GS"}},
+		// a weak match alongside a strong one for the same ticker still links
+		{"Block, Inc. earnings", "Block said revenue grew.", []string{"XYZ"}},
+		// but not alongside a strong one for a different ticker
+		{"$AAPL falls", "The WELL known KEY supplier sat at a LOW.", []string{"AAPL"}},
+	}
+	for _, test := range tests {
+		linked := linkedSymbols(index, test.title, test.text)
+		if len(linked) != len(test.want) {
+			t.Errorf("%q: linked %v, want %v", test.title+" "+test.text, linked, test.want)
+			continue
+		}
+		for i := range linked {
+			if linked[i] != test.want[i] {
+				t.Errorf("%q: linked %v, want %v", test.title+" "+test.text, linked, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Feed is an RSS or Atom feed we pull news from; TickerId is set for feeds
+// that are all about one company, like its investor relations news
+type Feed struct {
+	FeedId              uint64       `db:"feed_id"`
+	FeedURL             string       `db:"feed_url"`
+	SourceString        string       `db:"source_string"`
+	TickerId            uint64       `db:"ticker_id"`
+	Active              bool         `db:"active"`
+	LastFetchedDatetime sql.NullTime `db:"last_fetched_datetime"`
+	CreateDatetime      time.Time    `db:"create_datetime"`
+	UpdateDatetime      time.Time    `db:"update_datetime"`
+}
+
+func getActiveFeeds(deps *Dependencies) ([]Feed, error) {
+	db := deps.db
+
+	var feeds []Feed
+	err := db.Select(&feeds, "SELECT * FROM feed WHERE active=1 ORDER BY feed_id")
+	return feeds, err
+}
+
+func getFeedsByIds(deps *Dependencies, feedIds []uint64) ([]Feed, error) {
+	db := deps.db
+
+	query, args, err := sqlx.In("SELECT * FROM feed WHERE feed_id IN (?) ORDER BY feed_id", feedIds)
+	if err != nil {
+		return nil, err
+	}
+	var feeds []Feed
+	err = db.Select(&feeds, db.Rebind(query), args...)
+	return feeds, err
+}
+
+func (f *Feed) createFeed(deps *Dependencies) error {
+	db := deps.db
+
+	var insert = "INSERT INTO feed SET feed_url=?, source_string=?, ticker_id=?, active=1"
+	res, err := db.Exec(insert, f.FeedURL, f.SourceString, f.TickerId)
+	if err != nil {
+		return err
+	}
+	recordId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	f.FeedId = uint64(recordId)
+	return nil
+}
+
+func (f *Feed) updateLastFetched(deps *Dependencies) error {
+	db := deps.db
+
+	_, err := db.Exec("UPDATE feed SET last_fetched_datetime=now() WHERE feed_id=?", f.FeedId)
+	return err
+}
+
+// feedEntry is an RSS item or Atom entry, whichever it came as
+type feedEntry struct {
+	GUID      string
+	Title     string
+	Link      string
+	Published time.Time
+	Updated   time.Time
+	Body      string
+}
+
+// the parts of RSS 2.0 and Atom we use, in one struct since only one of
+// Channel or Entries gets filled in depending on the root element
+type feedDocument struct {
+	Channel struct {
+		Items []struct {
+			Title          string `xml:"title"`
+			Link           string `xml:"link"`
+			GUID           string `xml:"guid"`
+			PubDate        string `xml:"pubDate"`
+			DCDate         string `xml:"http://purl.org/dc/elements/1.1/ date"`
+			Updated        string `xml:"http://www.w3.org/2005/Atom updated"`
+			Description    string `xml:"description"`
+			ContentEncoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+		} `xml:"item"`
+	} `xml:"channel"`
+	Entries []struct {
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Id        string `xml:"id"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+	} `xml:"entry"`
+}
+
+var (
+	feedClient = &http.Client{Timeout: feedFetchTimeout * time.Second}
+
+	feedTimeLayouts = []string{
+		time.RFC1123Z, time.RFC1123, time.RFC3339, time.RFC3339Nano, time.RFC822Z, time.RFC822,
+		"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2 Jan 2006 15:04:05 -0700",
+		"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02",
+	}
+)
+
+// fetchFeed gets and parses a feed; only http and https feeds are read
+func fetchFeed(feedURL string) ([]feedEntry, error) {
+	if err := checkFeedURL(feedURL); err != nil {
+		return nil, err
+	}
+	resp, err := feedClient.Get(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedBytes))
+	if err != nil {
+		return nil, err
+	}
+	return parseFeed(data, feedURL)
+}
+
+// checkFeedURL refuses anything but an absolute http or https URL, so a feed
+// can't be pointed at a local file
+func checkFeedURL(feedURL string) error {
+	parsed, err := url.Parse(strings.TrimSpace(feedURL))
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("not an http or https feed url: %s", feedURL)
+	}
+	return nil
+}
+
+// parseFeed reads an RSS 2.0 or Atom document; relative links are resolved
+// against the feed's own URL
+func parseFeed(data []byte, feedURL string) ([]feedEntry, error) {
+	var document feedDocument
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// feeds in the wild are often not quite XML
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = feedCharsetReader
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	base, _ := url.Parse(feedURL)
+	entries := make([]feedEntry, 0, len(document.Channel.Items)+len(document.Entries))
+	for _, item := range document.Channel.Items {
+		body := item.ContentEncoded
+		if strings.TrimSpace(body) == "" {
+			body = item.Description
+		}
+		published := parseFeedTime(item.PubDate)
+		if published.IsZero() {
+			published = parseFeedTime(item.DCDate)
+		}
+		entries = append(entries, feedEntry{
+			GUID:      strings.TrimSpace(item.GUID),
+			Title:     feedText(item.Title),
+			Link:      resolveFeedLink(base, item.Link),
+			Published: published,
+			Updated:   parseFeedTime(item.Updated),
+			Body:      body,
+		})
+	}
+	for _, entry := range document.Entries {
+		link := ""
+		for _, l := range entry.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = l.Href
+				break
+			}
+		}
+		body := entry.Content
+		if strings.TrimSpace(body) == "" {
+			body = entry.Summary
+		}
+		entries = append(entries, feedEntry{
+			GUID:      strings.TrimSpace(entry.Id),
+			Title:     feedText(entry.Title),
+			Link:      resolveFeedLink(base, link),
+			Published: parseFeedTime(entry.Published),
+			Updated:   parseFeedTime(entry.Updated),
+			Body:      body,
+		})
+	}
+
+	for i := range entries {
+		if entries[i].GUID == "" {
+			entries[i].GUID = entries[i].Link
+		}
+		if entries[i].Published.IsZero() {
+			entries[i].Published = entries[i].Updated
+		}
+	}
+	return entries, nil
+}
+
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+	for _, layout := range feedTimeLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+// titles sometimes carry markup or entities of their own
+func feedText(value string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(articleText(value))), " ")
+}
+
+func resolveFeedLink(base *url.URL, link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" || base == nil {
+		return link
+	}
+	resolved, err := base.Parse(link)
+	if err != nil {
+		return ""
+	}
+	if safe, ok := safeContentURL(resolved.String()); ok {
+		return safe
+	}
+	return ""
+}
+
+// windows-1252 differs from Latin-1 only in 0x80-0x9F, where it has curly
+// quotes, dashes, the euro sign and such instead of control characters
+var cp1252High = [32]rune{
+	'\u20ac', '\u0081', '\u201a', '\u0192', '\u201e', '\u2026', '\u2020', '\u2021', '\u02c6', '\u2030', '\u0160', '\u2039', '\u0152', '\u008d', '\u017d', '\u008f',
+	'\u0090', '\u2018', '\u2019', '\u201c', '\u201d', '\u2022', '\u2013', '\u2014', '\u02dc', '\u2122', '\u0161', '\u203a', '\u0153', '\u009d', '\u017e', '\u0178',
+}
+
+// the XML decoder only knows UTF-8 itself; Latin-1 maps straight onto the
+// first 256 code points, windows-1252 nearly so, and ASCII is already UTF-8
+func feedCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	cp1252 := false
+	switch strings.ToLower(charset) {
+	case "us-ascii", "ascii", "utf8":
+		return input, nil
+	case "windows-1252", "cp1252":
+		cp1252 = true
+	case "iso-8859-1", "latin1", "latin-1":
+	default:
+		return nil, fmt.Errorf("unsupported feed charset %s", charset)
+	}
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+		if cp1252 && b >= 0x80 && b <= 0x9f {
+			runes[i] = cp1252High[b-0x80]
+		}
+	}
+	return strings.NewReader(string(runes)), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchFeed(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	tests := []struct {
+		file    string
+		entries []feedEntry
+	}{
+		{"rss.xml", []feedEntry{
+			{
+				GUID:      "example-2024-q3",
+				Title:     "Example Corp Reports Third Quarter Results & Raises Guidance",
+				Link:      "https://ir.example.com/news/q3-results",
+				Published: time.Date(2024, 10, 29, 20, 5, 0, 0, time.UTC),
+				Updated:   time.Date(2024, 10, 30, 13, 0, 0, 0, time.UTC),
+			},
+			{
+				// no guid, and a relative link
+				GUID:      server.URL + "/news/conference",
+				Title:     "Example Corp to Present at Conference",
+				Link:      server.URL + "/news/conference",
+				Published: time.Date(2024, 11, 4, 14, 30, 0, 0, time.UTC),
+			},
+		}},
+		{"atom.xml", []feedEntry{
+			{
+				GUID:      "urn:example:8k-1",
+				Title:     "8-K Current Report",
+				Link:      "https://filings.example.com/8k-1",
+				Published: time.Date(2024, 11, 1, 11, 0, 0, 0, time.UTC),
+				Updated:   time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC),
+			},
+			{
+				// no id, a relative link, and only an updated time
+				GUID:      server.URL + "/filings/10q-3",
+				Title:     "10-Q Quarterly Report",
+				Link:      server.URL + "/filings/10q-3",
+				Published: time.Date(2024, 10, 31, 13, 0, 0, 0, time.UTC),
+				Updated:   time.Date(2024, 10, 31, 13, 0, 0, 0, time.UTC),
+			},
+		}},
+		{"latin1.xml", []feedEntry{
+			{
+				GUID:      "exemple-t3",
+				Title:     "Résultats du troisième trimestre",
+				Link:      "https://exemple.fr/resultats",
+				Published: time.Date(2024, 10, 30, 6, 0, 0, 0, time.UTC),
+			},
+		}},
+		{"cp1252.xml", []feedEntry{
+			{
+				GUID:      "wire-record",
+				Title:     "“Record” Quarter – Sales Top €1 Billion",
+				Link:      "https://wire.example.com/record",
+				Published: time.Date(2024, 10, 31, 12, 0, 0, 0, time.UTC),
+			},
+		}},
+	}
+
+	for _, test := range tests {
+		entries, err := fetchFeed(server.URL + "/" + test.file)
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+			continue
+		}
+		if len(entries) != len(test.entries) {
+			t.Errorf("%s: got %d entries, want %d", test.file, len(entries), len(test.entries))
+			continue
+		}
+		for i, want := range test.entries {
+			got := entries[i]
+			if got.GUID != want.GUID || got.Title != want.Title || got.Link != want.Link {
+				t.Errorf("%s entry %d: got %q %q %q, want %q %q %q", test.file, i, got.GUID, got.Title, got.Link, want.GUID, want.Title, want.Link)
+			}
+			if !got.Published.Equal(want.Published) || !got.Updated.Equal(want.Updated) {
+				t.Errorf("%s entry %d: got published %v updated %v, want %v %v", test.file, i, got.Published, got.Updated, want.Published, want.Updated)
+			}
+			if got.Body == "" {
+				t.Errorf("%s entry %d: no body", test.file, i)
+			}
+		}
+	}
+}
+
+func TestFeedBodiesAreSanitized(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	entries, err := fetchFeed(server.URL + "/rss.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := sanitizeHTML(entries[0].Body)
+	if body != "<p>Revenue grew <b>12%</b>.</p><p>click</p>" {
+		t.Errorf("got body %q", body)
+	}
+	for _, bad := range []string{"script", "alert", "javascript"} {
+		if strings.Contains(body, bad) {
+			t.Errorf("body still has %s: %q", bad, body)
+		}
+	}
+}
+
+func TestFetchFeedRefusesOtherSchemes(t *testing.T) {
+	for _, feedURL := range []string{"file:///etc/passwd", "ftp://example.com/feed.xml", "/etc/passwd", "//example.com/feed.xml"} {
+		if _, err := fetchFeed(feedURL); err == nil {
+			t.Errorf("%s: expected an error", feedURL)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"time"
+)
+
+// restated values smaller than this (relative to the old value) are just
+// float noise from the provider, not real restatements
+const restatementTolerance = 1e-9
+
+type FinancialsRevision struct {
+	FinancialsRevisionId uint64       `db:"financials_revision_id"`
+	TickerId             uint64       `db:"ticker_id"`
+	FormName             string       `db:"form_name"`
+	FormTermName         string       `db:"form_term_name"`
+	ChartName            string       `db:"chart_name"`
+	ChartDatetime        sql.NullTime `db:"chart_datetime"`
+	OldValue             float64      `db:"old_value"`
+	NewValue             float64      `db:"new_value"`
+	DetectedDatetime     time.Time    `db:"detected_datetime"`
+	CreateDatetime       time.Time    `db:"create_datetime"`
+}
+
+// one line of the restatements report: every revision for a ticker and
+// reporting period, newest first
+type Restatement struct {
+	TickerSymbol     string       `db:"ticker_symbol"`
+	FormName         string       `db:"form_name"`
+	FormTermName     string       `db:"form_term_name"`
+	ChartName        string       `db:"chart_name"`
+	ChartDatetime    sql.NullTime `db:"chart_datetime"`
+	OldValue         float64      `db:"old_value"`
+	NewValue         float64      `db:"new_value"`
+	DetectedDatetime time.Time    `db:"detected_datetime"`
+}
+
+func isRestatement(oldValue, newValue float64) bool {
+	diff := math.Abs(newValue - oldValue)
+	return diff > restatementTolerance*math.Max(1, math.Abs(oldValue))
+}
+
+func (fr *FinancialsRevision) create(deps *Dependencies) error {
+	db := deps.db
+
+	var insert = "INSERT INTO financials_revision SET ticker_id=?, form_name=?, form_term_name=?, chart_name=?, chart_datetime=?, old_value=?, new_value=?, detected_datetime=?"
+	res, err := db.Exec(insert, fr.TickerId, fr.FormName, fr.FormTermName, fr.ChartName, fr.ChartDatetime, fr.OldValue, fr.NewValue, fr.DetectedDatetime)
+	if err != nil {
+		return err
+	}
+	recordId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	fr.FinancialsRevisionId = uint64(recordId)
+	return nil
+}
+
+// getRestatements lists revisions grouped by ticker and period, optionally
+// limited to a single symbol
+func getRestatements(deps *Dependencies, symbol string) ([]Restatement, error) {
+	db := deps.db
+
+	var restatements []Restatement
+	var query = `SELECT ticker.ticker_symbol, financials_revision.form_name, financials_revision.form_term_name,
+		financials_revision.chart_name, financials_revision.chart_datetime, financials_revision.old_value,
+		financials_revision.new_value, financials_revision.detected_datetime
+		FROM financials_revision
+		LEFT JOIN ticker ON (ticker.ticker_id=financials_revision.ticker_id)
+		WHERE ?='' OR ticker.ticker_symbol=?
+		ORDER BY ticker.ticker_symbol, financials_revision.chart_datetime DESC, financials_revision.form_name, financials_revision.chart_name, financials_revision.detected_datetime DESC`
+	err := db.Select(&restatements, query, symbol, symbol)
+	return restatements, err
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rs/zerolog"
+)
+
+// ArticleImage is an image an article uses, mirrored to our own bucket so
+// it survives the publisher moving it; the same image used by many articles
+// is stored once, under its content hash
+type ArticleImage struct {
+	ArticleImageId uint64    `db:"article_image_id"`
+	ArticleId      uint64    `db:"article_id"`
+	SourceURL      string    `db:"source_url"`
+	ContentHash    string    `db:"content_hash"`
+	ContentType    string    `db:"content_type"`
+	ByteSize       int       `db:"byte_size"`
+	S3Key          string    `db:"s3_key"`
+	CreateDatetime time.Time `db:"create_datetime"`
+	UpdateDatetime time.Time `db:"update_datetime"`
+}
+
+var (
+	// contentBuilder writes every image exactly like this
+	articleImageSrc = regexp.MustCompile(`<img src="([^"]*)">`)
+
+	// image URLs come from publishers, so the client only connects to
+	// public addresses; see publicDialControl
+	imageClient = &http.Client{
+		Timeout: articleImageTimeout * time.Second,
+		Transport: &http.Transport{
+			// no proxy, the check has to see the image host's own address
+			Proxy:               nil,
+			DialContext:         (&net.Dialer{Timeout: articleImageTimeout * time.Second, Control: publicDialControl}).DialContext,
+			TLSHandshakeTimeout: articleImageTimeout * time.Second,
+		},
+	}
+
+	// ranges that aren't covered by net.IP's own checks
+	nonPublicNetworks = []*net.IPNet{
+		mustParseCIDR("0.0.0.0/8"),     // "this" network
+		mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+		mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments
+		mustParseCIDR("198.18.0.0/15"), // benchmarking
+		mustParseCIDR("240.0.0.0/4"),   // reserved
+	}
+)
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// publicDialControl refuses to connect to anything but a public address,
+// so an image URL can't reach the cloud metadata service (169.254.169.254)
+// or hosts on our own network. It's called with the resolved address of
+// every connection, redirects included, so a hostname that resolves to a
+// private address is caught too.
+func publicDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func getArticleImageBySourceURL(deps *Dependencies, articleId uint64, sourceURL string) (ArticleImage, error) {
+	db := deps.db
+
+	var image ArticleImage
+	err := db.QueryRowx("SELECT * FROM article_image WHERE article_id=? AND source_url=? LIMIT 1", articleId, sourceURL).StructScan(&image)
+	return image, err
+}
+
+func getS3KeyByContentHash(deps *Dependencies, contentHash string) (string, error) {
+	db := deps.db
+
+	var s3Key string
+	err := db.QueryRowx("SELECT s3_key FROM article_image WHERE content_hash=? LIMIT 1", contentHash).Scan(&s3Key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return s3Key, err
+}
+
+func (ai *ArticleImage) createArticleImage(deps *Dependencies) error {
+	db := deps.db
+
+	var insert = "INSERT INTO article_image SET article_id=?, source_url=?, content_hash=?, content_type=?, byte_size=?, s3_key=?"
+	res, err := db.Exec(insert, ai.ArticleId, ai.SourceURL, ai.ContentHash, ai.ContentType, ai.ByteSize, ai.S3Key)
+	if err != nil {
+		return err
+	}
+	recordId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	ai.ArticleImageId = uint64(recordId)
+	return nil
+}
+
+// putPrivateObject stores data in the private bucket under s3Key
+func putPrivateObject(deps *Dependencies, s3Key string, data []byte, contentType string) error {
+	awssess := deps.awssess
+
+	s3svc := s3.New(awssess)
+	inputPutObj := &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(bytes.NewReader(data)),
+		Bucket: aws.String(awsPrivateBucketName),
+		Key:    aws.String(s3Key),
+	}
+	if contentType != "" {
+		inputPutObj.ContentType = aws.String(contentType)
+	}
+
+	_, err := s3svc.PutObject(inputPutObj)
+	return err
+}
+
+// mirrorArticleImages copies an article's image and every image in its body
+// to our bucket and points the article at our keys instead. An image that
+// can't be fetched keeps its remote URL, to be tried again if the article
+// is refreshed.
+func mirrorArticleImages(deps *Dependencies, sublog zerolog.Logger, article *Article) error {
+	db := deps.db
+
+	mirrored := make(map[string]string)
+	mirror := func(sourceURL string) string {
+		if s3Key, ok := mirrored[sourceURL]; ok {
+			return s3Key
+		}
+		s3Key := sourceURL
+		if _, ok := safeContentURL(sourceURL); ok {
+			image, err := mirrorArticleImage(deps, article.ArticleId, sourceURL)
+			if err != nil {
+				sublog.Warn().Err(err).Str("url", sourceURL).Msg("failed to mirror article image from {url}")
+			} else {
+				s3Key = image.S3Key
+			}
+		}
+		mirrored[sourceURL] = s3Key
+		return s3Key
+	}
+
+	imageURL := article.ImageURL
+	if imageURL != "" {
+		imageURL = mirror(imageURL)
+	}
+	body := articleImageSrc.ReplaceAllStringFunc(article.Body, func(tag string) string {
+		src := html.UnescapeString(articleImageSrc.FindStringSubmatch(tag)[1])
+		return `<img src="` + html.EscapeString(mirror(src)) + `">`
+	})
+
+	if imageURL == article.ImageURL && body == article.Body {
+		return nil
+	}
+	article.ImageURL, article.Body = imageURL, body
+	_, err := db.Exec("UPDATE article SET image_url=?, body=? WHERE article_id=?", article.ImageURL, article.Body, article.ArticleId)
+	return err
+}
+
+// mirrorArticleImage downloads an image and stores it, unless this article
+// already has it or some other article has the same image
+func mirrorArticleImage(deps *Dependencies, articleId uint64, sourceURL string) (ArticleImage, error) {
+	image, err := getArticleImageBySourceURL(deps, articleId, sourceURL)
+	if err == nil {
+		return image, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return image, err
+	}
+
+	resp, err := imageClient.Get(sourceURL)
+	if err != nil {
+		return image, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return image, fmt.Errorf("image returned %s", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArticleImageBytes+1))
+	if err != nil {
+		return image, err
+	}
+	if len(data) > maxArticleImageBytes {
+		return image, fmt.Errorf("image is over %d bytes", maxArticleImageBytes)
+	}
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return image, fmt.Errorf("not an image (%s)", contentType)
+	}
+
+	contentHash := fmt.Sprintf("%x", sha1.Sum(data))
+	s3Key, err := getS3KeyByContentHash(deps, contentHash)
+	if err != nil {
+		return image, err
+	}
+	if s3Key == "" {
+		s3Key = articleImagePrefix + contentHash
+		if err := putPrivateObject(deps, s3Key, data, contentType); err != nil {
+			return image, err
+		}
+	}
+
+	image = ArticleImage{ArticleId: articleId, SourceURL: sourceURL, ContentHash: contentHash, ContentType: contentType, ByteSize: len(data), S3Key: s3Key}
+	return image, image.createArticleImage(deps)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"169.254.169.254", false},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:10.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, test := range tests {
+		if got := isPublicIP(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestImageClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+	defer server.Close()
+
+	// the test server is on loopback, which is as private as it gets
+	resp, err := imageClient.Get(server.URL + "/image.png")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("fetched an image from a loopback address")
+	}
+
+	if err := publicDialControl("tcp", "169.254.169.254:80", nil); err == nil {
+		t.Error("allowed a connection to the metadata service")
+	}
+	if err := publicDialControl("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("refused a public address: %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TickerIndicator is one day of technical indicators for a ticker, all
+// computed from the split-adjusted series. The EMAs behind MACD and the
+// Wilder averages behind RSI are stored too, so the next day can carry on
+// from them instead of replaying the whole history.
+type TickerIndicator struct {
+	TickerIndicatorId uint64          `db:"ticker_indicator_id"`
+	TickerId          uint64          `db:"ticker_id"`
+	PriceDate         time.Time       `db:"price_date"`
+	Sma20             sql.NullFloat64 `db:"sma20"`
+	Sma50             sql.NullFloat64 `db:"sma50"`
+	Sma200            sql.NullFloat64 `db:"sma200"`
+	Ema20             sql.NullFloat64 `db:"ema20"`
+	Ema50             sql.NullFloat64 `db:"ema50"`
+	Ema200            sql.NullFloat64 `db:"ema200"`
+	Ema12             sql.NullFloat64 `db:"ema12"`
+	Ema26             sql.NullFloat64 `db:"ema26"`
+	Macd              sql.NullFloat64 `db:"macd"`
+	MacdSignal        sql.NullFloat64 `db:"macd_signal"`
+	MacdHist          sql.NullFloat64 `db:"macd_hist"`
+	Rsi14             sql.NullFloat64 `db:"rsi14"`
+	RsiAvgGain        sql.NullFloat64 `db:"rsi_avg_gain"`
+	RsiAvgLoss        sql.NullFloat64 `db:"rsi_avg_loss"`
+	BollingerUpper    sql.NullFloat64 `db:"bollinger_upper"`
+	BollingerLower    sql.NullFloat64 `db:"bollinger_lower"`
+	Atr14             sql.NullFloat64 `db:"atr14"`
+	High52Week        sql.NullFloat64 `db:"high_52week"`
+	Low52Week         sql.NullFloat64 `db:"low_52week"`
+	CreateDatetime    time.Time       `db:"create_datetime"`
+	UpdateDatetime    time.Time       `db:"update_datetime"`
+}
+
+func (ti *TickerIndicator) getLatest(deps *Dependencies, tickerId uint64) error {
+	db := deps.db
+
+	return db.QueryRowx("SELECT * FROM ticker_indicator WHERE ticker_id=? ORDER BY price_date DESC LIMIT 1", tickerId).StructScan(ti)
+}
+
+// saveTickerIndicators stores newly computed indicators; with replace, the
+// ticker's existing ones are deleted first, in the same transaction so
+// readers never see the ticker without any
+func saveTickerIndicators(deps *Dependencies, tickerId uint64, indicators []TickerIndicator, replace bool) error {
+	db := deps.db
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	if replace {
+		if _, err := tx.Exec("DELETE FROM ticker_indicator WHERE ticker_id=?", tickerId); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	insert, err := tx.Prepare("INSERT INTO ticker_indicator SET ticker_id=?, price_date=?, sma20=?, sma50=?, sma200=?, ema20=?, ema50=?, ema200=?, ema12=?, ema26=?, macd=?, macd_signal=?, macd_hist=?, rsi14=?, rsi_avg_gain=?, rsi_avg_loss=?, bollinger_upper=?, bollinger_lower=?, atr14=?, high_52week=?, low_52week=?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer insert.Close()
+
+	for _, ti := range indicators {
+		_, err := insert.Exec(ti.TickerId, ti.PriceDate.Format("2006-01-02"), ti.Sma20, ti.Sma50, ti.Sma200, ti.Ema20, ti.Ema50, ti.Ema200, ti.Ema12, ti.Ema26, ti.Macd, ti.MacdSignal, ti.MacdHist, ti.Rsi14, ti.RsiAvgGain, ti.RsiAvgLoss, ti.BollingerUpper, ti.BollingerLower, ti.Atr14, ti.High52Week, ti.Low52Week)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// indicatorBar is the split-adjusted part of a ticker_daily row
+type indicatorBar struct {
+	PriceDatetime time.Time `db:"price_datetime"`
+	HighPrice     float64   `db:"adj_high_price"`
+	LowPrice      float64   `db:"adj_low_price"`
+	ClosePrice    float64   `db:"adj_close_price"`
+}
+
+func getIndicatorBars(deps *Dependencies, tickerId uint64, since time.Time) ([]indicatorBar, error) {
+	db := deps.db
+
+	var bars []indicatorBar
+	err := db.Select(&bars, "SELECT price_datetime, adj_high_price, adj_low_price, adj_close_price FROM ticker_daily WHERE ticker_id=? AND adj_close_price IS NOT NULL AND price_date >= ? ORDER BY price_date", tickerId, since.Format("2006-01-02"))
+	return bars, err
+}
+
+// updateTickerIndicators computes indicators for every bar newer than the
+// last one we have. With full (or when there's nothing to carry on from)
+// the ticker's indicators are thrown away and recomputed from its first
+// bar, which is needed whenever older adjusted prices change, i.e. after a
+// new split, or when adjustedFrom (the earliest bar adjustTickerDailies
+// just wrote, zero if none) is at or before the last computed day.
+func updateTickerIndicators(deps *Dependencies, sublog zerolog.Logger, tickerId uint64, adjustedFrom time.Time, full bool) error {
+	state := newIndicatorState()
+	since := time.Time{}
+	latest := TickerIndicator{}
+	warm := false
+	if !full {
+		err := latest.getLatest(deps, tickerId)
+		switch {
+		case err == sql.ErrNoRows:
+			full = true
+		case err != nil:
+			return err
+		case !adjustedFrom.IsZero() && !adjustedFrom.After(latest.PriceDate):
+			full = true
+		case state.seed(latest):
+			since = latest.PriceDate.AddDate(0, 0, -indicatorLookbackDays)
+		default:
+			// too little history yet for some of them, so those are
+			// replayed from the first bar
+			warm = true
+		}
+	}
+	if full {
+		state = newIndicatorState()
+	}
+
+	bars, err := getIndicatorBars(deps, tickerId, since)
+	if err != nil {
+		return err
+	}
+
+	from := 0
+	if !full {
+		lastDate := latest.PriceDate.Format("2006-01-02")
+		from = sort.Search(len(bars), func(i int) bool { return bars[i].PriceDatetime.Format("2006-01-02") > lastDate })
+		if from == 0 {
+			// the bar we'd carry on from is gone
+			return updateTickerIndicators(deps, sublog, tickerId, time.Time{}, true)
+		}
+		if from == len(bars) {
+			return nil
+		}
+		if warm {
+			state.warm(tickerId, bars[:from])
+		}
+	}
+
+	indicators := computeTickerIndicators(tickerId, bars, from, state)
+	err = saveTickerIndicators(deps, tickerId, indicators, full)
+	if err != nil {
+		return err
+	}
+	sublog.Info().Int("indicators", len(indicators)).Bool("full", full).Msg("computed indicators for {indicators} days for {symbol}")
+	return nil
+}
+
+// smoother is a running EMA (or Wilder average, which is just an EMA with a
+// slower alpha) that starts as the simple average of its first period values
+type smoother struct {
+	period int
+	alpha  float64
+	value  float64
+	count  int
+	sum    float64
+}
+
+func newEMA(period int) *smoother {
+	return &smoother{period: period, alpha: 2 / float64(period+1)}
+}
+
+func newWilder(period int) *smoother {
+	return &smoother{period: period, alpha: 1 / float64(period)}
+}
+
+// seed carries on from a stored value; false if there isn't one
+func (s *smoother) seed(value sql.NullFloat64) bool {
+	if !value.Valid {
+		return false
+	}
+	s.value = value.Float64
+	s.count = s.period
+	return true
+}
+
+func (s *smoother) add(x float64) sql.NullFloat64 {
+	if s.count < s.period {
+		s.sum += x
+		s.count++
+		if s.count < s.period {
+			return sql.NullFloat64{}
+		}
+		s.value = s.sum / float64(s.period)
+	} else {
+		s.value += s.alpha * (x - s.value)
+	}
+	return sql.NullFloat64{Valid: true, Float64: s.value}
+}
+
+// the recursive indicators, which depend on every bar before them
+type indicatorState struct {
+	ema20, ema50, ema200 *smoother
+	ema12, ema26, signal *smoother
+	gain, loss, atr      *smoother
+}
+
+func newIndicatorState() *indicatorState {
+	return &indicatorState{
+		ema20:  newEMA(20),
+		ema50:  newEMA(50),
+		ema200: newEMA(200),
+		ema12:  newEMA(12),
+		ema26:  newEMA(26),
+		signal: newEMA(9),
+		gain:   newWilder(14),
+		loss:   newWilder(14),
+		atr:    newWilder(14),
+	}
+}
+
+// smoothers lists the state's smoothers alongside the stored column each one
+// carries on from
+func (is *indicatorState) smoothers(ti TickerIndicator) ([]*smoother, []sql.NullFloat64) {
+	return []*smoother{is.ema20, is.ema50, is.ema200, is.ema12, is.ema26, is.signal, is.gain, is.loss, is.atr},
+		[]sql.NullFloat64{ti.Ema20, ti.Ema50, ti.Ema200, ti.Ema12, ti.Ema26, ti.MacdSignal, ti.RsiAvgGain, ti.RsiAvgLoss, ti.Atr14}
+}
+
+// seed picks up each smoother from the last stored day; false if any of
+// them couldn't be, which is normal until a ticker has enough history for
+// the slower ones
+func (is *indicatorState) seed(ti TickerIndicator) bool {
+	seeded := true
+	smoothers, values := is.smoothers(ti)
+	for i, s := range smoothers {
+		if !s.seed(values[i]) {
+			seeded = false
+		}
+	}
+	return seeded
+}
+
+// warm fills in the smoothers seed couldn't by replaying bars, which must
+// start at the ticker's first bar; the stored values for the others are
+// left alone
+func (is *indicatorState) warm(tickerId uint64, bars []indicatorBar) {
+	replayed := newIndicatorState()
+	computeTickerIndicators(tickerId, bars, 0, replayed)
+
+	smoothers, _ := is.smoothers(TickerIndicator{})
+	replayedSmoothers, _ := replayed.smoothers(TickerIndicator{})
+	for i, s := range smoothers {
+		if s.count < s.period {
+			*s = *replayedSmoothers[i]
+		}
+	}
+}
+
+// computeTickerIndicators computes indicators for bars[from:]; the bars
+// before from are only there for the moving windows and previous closes
+func computeTickerIndicators(tickerId uint64, bars []indicatorBar, from int, state *indicatorState) []TickerIndicator {
+	indicators := make([]TickerIndicator, 0, len(bars)-from)
+
+	for i := from; i < len(bars); i++ {
+		bar := bars[i]
+		ti := TickerIndicator{TickerId: tickerId, PriceDate: bar.PriceDatetime}
+
+		ti.Sma20 = simpleAverage(bars, i, 20)
+		ti.Sma50 = simpleAverage(bars, i, 50)
+		ti.Sma200 = simpleAverage(bars, i, 200)
+		if ti.Sma20.Valid {
+			deviation := standardDeviation(bars, i, 20, ti.Sma20.Float64)
+			ti.BollingerUpper = sql.NullFloat64{Valid: true, Float64: ti.Sma20.Float64 + 2*deviation}
+			ti.BollingerLower = sql.NullFloat64{Valid: true, Float64: ti.Sma20.Float64 - 2*deviation}
+		}
+
+		ti.Ema20 = state.ema20.add(bar.ClosePrice)
+		ti.Ema50 = state.ema50.add(bar.ClosePrice)
+		ti.Ema200 = state.ema200.add(bar.ClosePrice)
+		ti.Ema12 = state.ema12.add(bar.ClosePrice)
+		ti.Ema26 = state.ema26.add(bar.ClosePrice)
+		if ti.Ema12.Valid && ti.Ema26.Valid {
+			ti.Macd = sql.NullFloat64{Valid: true, Float64: ti.Ema12.Float64 - ti.Ema26.Float64}
+			ti.MacdSignal = state.signal.add(ti.Macd.Float64)
+			if ti.MacdSignal.Valid {
+				ti.MacdHist = sql.NullFloat64{Valid: true, Float64: ti.Macd.Float64 - ti.MacdSignal.Float64}
+			}
+		}
+
+		trueRange := bar.HighPrice - bar.LowPrice
+		if i > 0 {
+			prevClose := bars[i-1].ClosePrice
+			change := bar.ClosePrice - prevClose
+			ti.RsiAvgGain = state.gain.add(math.Max(change, 0))
+			ti.RsiAvgLoss = state.loss.add(math.Max(-change, 0))
+			if ti.RsiAvgGain.Valid && ti.RsiAvgLoss.Valid {
+				rsi := 100.0
+				if ti.RsiAvgLoss.Float64 > 0 {
+					rsi = 100 - 100/(1+ti.RsiAvgGain.Float64/ti.RsiAvgLoss.Float64)
+				}
+				ti.Rsi14 = sql.NullFloat64{Valid: true, Float64: rsi}
+			}
+			trueRange = math.Max(trueRange, math.Max(math.Abs(bar.HighPrice-prevClose), math.Abs(bar.LowPrice-prevClose)))
+		}
+		ti.Atr14 = state.atr.add(trueRange)
+
+		// by date rather than a count of bars, so it's a year whatever holes
+		// there are in the history
+		cutoff := bar.PriceDatetime.AddDate(-1, 0, 0)
+		high, low := bar.HighPrice, bar.LowPrice
+		for j := i - 1; j >= 0 && bars[j].PriceDatetime.After(cutoff); j-- {
+			high = math.Max(high, bars[j].HighPrice)
+			low = math.Min(low, bars[j].LowPrice)
+		}
+		ti.High52Week = sql.NullFloat64{Valid: true, Float64: high}
+		ti.Low52Week = sql.NullFloat64{Valid: true, Float64: low}
+
+		indicators = append(indicators, ti)
+	}
+	return indicators
+}
+
+// simpleAverage of the closes of the period bars ending at bars[i]
+func simpleAverage(bars []indicatorBar, i, period int) sql.NullFloat64 {
+	if i+1 < period {
+		return sql.NullFloat64{}
+	}
+	sum := 0.0
+	for j := i - period + 1; j <= i; j++ {
+		sum += bars[j].ClosePrice
+	}
+	return sql.NullFloat64{Valid: true, Float64: sum / float64(period)}
+}
+
+func standardDeviation(bars []indicatorBar, i, period int, mean float64) float64 {
+	sum := 0.0
+	for j := i - period + 1; j <= i; j++ {
+		sum += (bars[j].ClosePrice - mean) * (bars[j].ClosePrice - mean)
+	}
+	return math.Sqrt(sum / float64(period))
+}
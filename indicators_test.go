@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func indicatorBars(count int) []indicatorBar {
+	bars := make([]indicatorBar, count)
+	for i := range bars {
+		close := 50 + 10*math.Sin(float64(i)/7) + float64(i%5)
+		bars[i] = indicatorBar{
+			PriceDatetime: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i),
+			HighPrice:     close + 1,
+			LowPrice:      close - 1.5,
+			ClosePrice:    close,
+		}
+	}
+	return bars
+}
+
+// carrying on from a stored day has to give the same indicators as
+// computing every day from scratch
+func TestIndicatorsCarryOn(t *testing.T) {
+	tests := []struct {
+		name   string
+		bars   int
+		stored int
+	}{
+		{"long history", 300, 260},
+		{"too short for ema200", 80, 60},
+		{"too short for the signal line", 30, 20},
+		{"too short for any smoother", 12, 5},
+	}
+	for _, test := range tests {
+		bars := indicatorBars(test.bars)
+		want := computeTickerIndicators(1, bars, 0, newIndicatorState())
+
+		state := newIndicatorState()
+		if !state.seed(want[test.stored-1]) {
+			state.warm(1, bars[:test.stored])
+		}
+		got := computeTickerIndicators(1, bars, test.stored, state)
+		if len(got) != test.bars-test.stored {
+			t.Fatalf("%s: got %d days, want %d", test.name, len(got), test.bars-test.stored)
+		}
+		for i := range got {
+			if !reflect.DeepEqual(got[i], want[test.stored+i]) {
+				t.Errorf("%s: day %d: got %+v, want %+v", test.name, test.stored+i, got[i], want[test.stored+i])
+				break
+			}
+		}
+	}
+}
+
+func TestIndicatorStateSeed(t *testing.T) {
+	bars := indicatorBars(80)
+	stored := computeTickerIndicators(1, bars, 0, newIndicatorState())[79]
+	if stored.Ema200.Valid {
+		t.Fatal("80 days shouldn't have an ema200")
+	}
+
+	state := newIndicatorState()
+	if state.seed(stored) {
+		t.Error("seeded without an ema200")
+	}
+	// everything that was stored is still picked up
+	if state.ema50.count != state.ema50.period || state.ema50.value != stored.Ema50.Float64 {
+		t.Errorf("ema50 not seeded: %+v", state.ema50)
+	}
+	if state.ema200.count != 0 {
+		t.Errorf("ema200 seeded from nothing: %+v", state.ema200)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"os"
 	"regexp"
 	"time"
 
@@ -15,16 +17,54 @@ const (
 	maxSleepTime          = 600.0 // seconds
 	highRateOfQueueChecks = 60    // at 60 checks/min we stop and take a longPause
 	longPause             = 10    // minutes
+	taskVisibilityTimeout = 900   // seconds, long enough for a task of schedulerTickersPerTask tickers
+
+	tickersQueueName = "stockwatch-tickers"
 
 	awsRegion            = "us-east-1"
 	awsPrivateBucketName = "stockwatch-private"
 
 	sqlDateTime = "2006-01-02 15:04:05"
 
-	minTickerFavIconDelay    = 60 * 24 * 30 // 30 days
-	minTickerFinancialsDelay = 60 * 1       // 1 hour
-	minTickerNewsDelay       = 60 * 1       // 1 hour
-	minTickerEODsDelay       = 60 * 24      // 24 hours
+	minTickerFavIconDelay      = 60 * 24 * 30 // 30 days
+	minTickerFavIconRetryDelay = 60 * 24      // 24 hours, after a failed fetch
+	minTickerFinancialsDelay   = 60 * 1       // 1 hour
+	minTickerNewsDelay         = 60 * 1       // 1 hour
+	minTickerEODsDelay         = 60 * 24      // 24 hours (scheduler only, eods follows the trading calendar)
+	minTickerIntradayDelay     = 5            // 5 minutes
+	minTickerProfileDelay      = 60 * 24 * 7  // 7 days
+	minTickerGapsDelay         = 60 * 24 * 7  // 7 days
+	minTickerEarningsDelay     = 60 * 24      // 24 hours
+
+	earningsFinancialsDelay    = 60 * 2 // minutes after the close on the report date, late enough to catch after-the-close reports
+	earningsFinancialsLookback = 7      // days after a report we keep trying to refresh financials
+	earningsQuarterSlack       = 7      // days a guessed quarter end can be off from the reported one
+
+	eodsPostCloseDelay    = 60  // minutes after the close before the day's EOD bar is available
+	eodsBackfillChunkDays = 365 // days requested per yhfinance call when loading a date range
+	eodsOutlierMove       = 0.5 // a day-over-day close move bigger than this, without a split, is suspect
+	indicatorLookbackDays = 400 // calendar days of bars loaded to carry indicators forward, enough for the 200-day and 52-week windows
+
+	maxQuotesPerRequest   = 50 // symbols per yhfinance marketQuotes call
+	maxTaskTickerAttempts = 3  // tries for each ticker in a multi-ticker task
+
+	gapsLookbackDays         = 365 * 5 // how far back to look for missing days
+	maxGapBackfillsPerTicker = 10      // backfill tasks enqueued per ticker per check
+	maxGapBackfillAttempts   = 3       // after this many, the provider just doesn't have it
+
+	minFeedDelay     = 30               // minutes between reads of the same feed
+	maxFeedBytes     = 10 * 1024 * 1024 // feeds bigger than this are cut off
+	feedFetchTimeout = 30               // seconds
+
+	articleImagePrefix   = "Articles/Images/" // S3 key prefix for mirrored article images
+	maxArticleImageBytes = 5 * 1024 * 1024    // images bigger than this aren't mirrored
+	articleImageTimeout  = 30                 // seconds
+
+	searchAPIAddress = "127.0.0.1:8090" // where the searchapi command listens unless told otherwise
+
+	defaultIntradayInterval     = "5m"
+	intradayRetention           = 60 * 24 * 30 // 30 days
+	minTickerIntradayPruneDelay = 60 * 24      // 24 hours
 
 	debugging = true
 )
@@ -43,6 +83,15 @@ func main() {
 	setupAWS(deps)
 	setupSecrets(deps)
 
+	// any arguments mean run a command instead of the queue loop
+	if len(os.Args) > 1 {
+		if err := runCommand(deps, os.Args[1], os.Args[2:]); err != nil {
+			deps.logger.Error().Err(err).Str("command", os.Args[1]).Msg("{command} failed")
+			os.Exit(1)
+		}
+		return
+	}
+
 	mainLoop(deps)
 }
 
@@ -70,7 +119,7 @@ func mainLoop(deps *Dependencies) {
 			timer = time.Now()
 		}
 
-		wasProcessed, err = getTask(deps, "stockwatch-tickers")
+		wasProcessed, err = getTask(deps, tickersQueueName)
 		if err != nil {
 			sublog.Error().Err(err).Msg("task failed: {error}")
 		}
@@ -121,7 +170,7 @@ func getTask(deps *Dependencies, queueName string) (bool, error) {
 		},
 		QueueUrl:            queueURL,
 		MaxNumberOfMessages: aws.Int64(1),
-		VisibilityTimeout:   aws.Int64(60),
+		VisibilityTimeout:   aws.Int64(taskVisibilityTimeout),
 	})
 	if err != nil {
 		sublog.Error().Err(err).Msg("failed to get next message in queue")
@@ -170,6 +219,16 @@ func getTask(deps *Dependencies, queueName string) (bool, error) {
 		success, err = perform_tickers_financials(deps, tasklog, body)
 	case "favicon":
 		success, err = perform_tickers_favicon(deps, tasklog, body)
+	case "profile":
+		success, err = perform_tickers_profile(deps, tasklog, body)
+	case "quotes":
+		success, err = perform_tickers_quotes(deps, tasklog, body)
+	case "gaps":
+		success, err = perform_tickers_gaps(deps, tasklog, body)
+	case "earnings":
+		success, err = perform_tickers_earnings(deps, tasklog, body)
+	case "feeds":
+		success, err = perform_feeds(deps, tasklog, body)
 	default:
 		success = false
 		taskError = fmt.Sprintf("unknown action string (%s) in queued task", action)
@@ -211,3 +270,33 @@ func deleteTask(deps *Dependencies, messageHandle, queueURL *string, taskError s
 
 	return (taskError == ""), err
 }
+
+func enqueueTask(deps *Dependencies, queueName, action string, body interface{}) error {
+	awssess := deps.awssess
+
+	awssvc := sqs.New(awssess)
+
+	messageBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	urlResult, err := awssvc.GetQueueUrl(&sqs.GetQueueUrlInput{
+		QueueName: &queueName,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = awssvc.SendMessage(&sqs.SendMessageInput{
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"action": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(action),
+			},
+		},
+		MessageBody: aws.String(string(messageBody)),
+		QueueUrl:    urlResult.QueueUrl,
+	})
+	return err
+}
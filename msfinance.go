@@ -2,7 +2,9 @@ package main
 
 import (
 	"database/sql"
+	"html"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/weirdtangent/msfinance"
@@ -47,41 +49,86 @@ func loadMSNews(deps *Dependencies, ticker Ticker) error {
 			}
 
 			for _, story := range newsListResponse {
-				sourceId, err := getSourceId(deps, story.SourceId)
+				source, err := getOrCreateSource(deps, story.SourceId)
 				if err != nil {
-					sublog.Error().Err(err).Msg("unknown source, skipping news article")
+					sublog.Error().Err(err).Str("source", story.SourceId).Msg("failed to get source, skipping news article")
 					continue
 				}
+				if source.Status == sourceBlocked {
+					continue
+				}
+				sourceId := source.SourceId
 
-				if existingId, err := getArticleByExternalId(deps, story.InternalId); err != nil {
-					sublog.Info().Err(err).Str("existing_id", story.InternalId)
-				} else if existingId != 0 {
+				publishedDateTime, err := time.Parse("2006-01-02T15:04:05-07:00", story.Published)
+				if err != nil {
+					sublog.Error().Err(err).Msg("could not parse Published")
 					continue
-				} else {
-					content, err := getNewsItemContent(deps, story.SourceId, story.InternalId)
-					if err != nil || len(content) == 0 {
-						sublog.Error().Err(err).Msg("no news item content found")
+				}
+
+				existingId, err := getArticleByExternalId(deps, story.InternalId)
+				if err != nil {
+					sublog.Info().Err(err).Str("existing_id", story.InternalId).Msg("failed to check for existing article by external id")
+					continue
+				}
+				var existing Article
+				if existingId != 0 {
+					// the news list has no separate updated time, so a story
+					// is only refreshed when it's republished with a newer
+					// published time; edits made without one aren't seen,
+					// since refetching every story's content to compare
+					// isn't worth it
+					existing = Article{ArticleId: existingId}
+					if err := existing.getArticleById(deps); err != nil {
+						sublog.Warn().Err(err).Msg("failed to load existing news article")
 						continue
 					}
-
-					publishedDateTime, err := time.Parse("2006-01-02T15:04:05-07:00", story.Published)
-					if err != nil {
-						sublog.Error().Err(err).Msg("could not parse Published")
+					// only the article itself, not one it's an alias of
+					if existing.ExternalId != story.InternalId || existing.SourceId != sourceId {
 						continue
 					}
+					if existing.PubUpdatedDatetime.Valid && !publishedDateTime.After(existing.PubUpdatedDatetime.Time) {
+						continue
+					}
+				}
 
-					article := Article{0, sourceId, story.InternalId, sql.NullTime{Valid: true, Time: publishedDateTime}, sql.NullTime{Valid: true, Time: publishedDateTime}, story.Title, content, "", "", time.Now(), time.Now()}
+				content, err := getNewsItemContent(deps, story.SourceId, story.InternalId)
+				if err != nil || len(content) == 0 {
+					sublog.Error().Err(err).Msg("no news item content found")
+					continue
+				}
 
-					err = article.createArticle(deps)
-					if err != nil {
-						sublog.Warn().Err(err).Str("symbol", ticker.TickerSymbol).Msg("failed to write new news article")
-					}
+				article := Article{
+					SourceId:           sourceId,
+					ExternalId:         story.InternalId,
+					PublishedDatetime:  sql.NullTime{Valid: true, Time: publishedDateTime},
+					PubUpdatedDatetime: sql.NullTime{Valid: true, Time: publishedDateTime},
+					Title:              story.Title,
+					Body:               content,
+				}
 
-					articleTicker := ArticleTicker{0, article.ArticleId, ticker.TickerSymbol, ticker.TickerId, time.Now(), time.Now()}
-					err = articleTicker.createArticleTicker(deps)
+				if existingId != 0 {
+					refreshed, err := existing.refreshArticle(deps, article)
 					if err != nil {
-						sublog.Warn().Err(err).Str("symbol", ticker.TickerSymbol).Msg("failed to write ticker(s) for new article")
+						sublog.Warn().Err(err).Msg("failed to refresh updated news article")
+					}
+					if refreshed {
+						err = linkArticleTickers(deps, sublog, existing, ticker)
+						if err != nil {
+							sublog.Warn().Err(err).Msg("failed to write ticker(s) for updated news article")
+						}
 					}
+					continue
+				}
+
+				_, err = article.saveArticle(deps)
+				if err != nil {
+					sublog.Warn().Err(err).Str("symbol", ticker.TickerSymbol).Msg("failed to write new news article")
+					continue
+				}
+
+				err = linkArticleTickers(deps, sublog, article, ticker)
+				if err != nil {
+					sublog.Warn().Err(err).Str("symbol", ticker.TickerSymbol).Msg("failed to write ticker(s) for new article")
 				}
 			}
 		}
@@ -106,32 +153,74 @@ func getNewsItemContent(deps *Dependencies, sourceId string, internalId string)
 	return newsContent, nil
 }
 
+// followContent turns a news item's content tree into HTML for
+// article.body. Everything in it comes from the provider, so it all goes
+// through contentBuilder rather than being pasted in as-is.
 func followContent(contentObj []msfinance.MSNewsContentObj) string {
-	noSpaces := regexp.MustCompile(`^\S+$`)
+	var builder contentBuilder
+	buildContent(&builder, contentObj, 0)
+	return builder.String()
+}
+
+var noSpaces = regexp.MustCompile(`^\S+$`)
+
+// buildContent writes a level of the content tree; depth is how far down
+// the tree it is, anything past maxContentDepth is dropped
+func buildContent(builder *contentBuilder, contentObj []msfinance.MSNewsContentObj, depth int) {
+	if depth > maxContentDepth {
+		return
+	}
 
-	var content string
 	for _, contentPiece := range contentObj {
-		var deeperContent string
-		if len(contentPiece.ContentObj) > 0 {
-			deeperContent = followContent(contentPiece.ContentObj)
-		} else {
-			deeperContent = contentPiece.Content
-		}
-		switch contentPiece.Type {
-		case "text":
-			content += deeperContent
-		case "img":
-			content += `<img src="` + contentPiece.Src + `">`
-		case "a":
-			if noSpaces.MatchString(deeperContent) {
-				content += `<a href="` + deeperContent + `">` + deeperContent + `</a>`
+		tag := strings.ToLower(strings.TrimSpace(contentPiece.Type))
+		switch {
+		case tag == "text":
+			buildContentChildren(builder, contentPiece, depth)
+		case tag == "img":
+			builder.image(html.UnescapeString(contentPiece.Src))
+		case tag == "a":
+			// links only come through as a bare URL for their text
+			text := html.UnescapeString(contentText(contentPiece, depth))
+			if noSpaces.MatchString(text) {
+				builder.link(text, text)
 			} else {
-				content += deeperContent
+				builder.text(text)
 			}
+		case droppedContentTags[tag]:
+			continue
 		default:
-			content += `<` + contentPiece.Type + `>` + deeperContent + `</` + contentPiece.Type + `>`
+			opened := builder.open(tag)
+			if opened && voidContentTags[tag] {
+				continue
+			}
+			buildContentChildren(builder, contentPiece, depth)
+			if opened {
+				builder.close(tag)
+			}
 		}
 	}
+}
+
+func buildContentChildren(builder *contentBuilder, contentPiece msfinance.MSNewsContentObj, depth int) {
+	if len(contentPiece.ContentObj) > 0 {
+		buildContent(builder, contentPiece.ContentObj, depth+1)
+	} else {
+		builder.escapedText(contentPiece.Content)
+	}
+}
 
-	return content
+// contentText is just the text of a piece of content and everything under
+// it, down to maxContentDepth
+func contentText(contentPiece msfinance.MSNewsContentObj, depth int) string {
+	if len(contentPiece.ContentObj) == 0 {
+		return contentPiece.Content
+	}
+	if depth >= maxContentDepth {
+		return ""
+	}
+	var text strings.Builder
+	for _, child := range contentPiece.ContentObj {
+		text.WriteString(contentText(child, depth+1))
+	}
+	return text.String()
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/weirdtangent/msfinance"
+	xhtml "golang.org/x/net/html"
+)
+
+// contentTree builds a content tree from fuzz input: each byte of shape
+// adds a node (taking its type, content and src from the pools in turn) as
+// a sibling, a child, or closes the current level
+func contentTree(shape []byte, types, contents, srcs []string) []msfinance.MSNewsContentObj {
+	pick := func(pool []string, b byte) string {
+		return pool[int(b)%len(pool)]
+	}
+
+	type level struct{ nodes []msfinance.MSNewsContentObj }
+	stack := []*level{{}}
+	for i, b := range shape {
+		top := stack[len(stack)-1]
+		switch b % 4 {
+		case 0:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+				parent := stack[len(stack)-1]
+				parent.nodes[len(parent.nodes)-1].ContentObj = top.nodes
+			}
+		case 1:
+			if len(top.nodes) > 0 {
+				stack = append(stack, &level{})
+			}
+			fallthrough
+		default:
+			node := msfinance.MSNewsContentObj{Type: pick(types, b), Content: pick(contents, byte(i)+b), Src: pick(srcs, b>>2)}
+			stack[len(stack)-1].nodes = append(stack[len(stack)-1].nodes, node)
+		}
+	}
+	for len(stack) > 1 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		parent := stack[len(stack)-1]
+		parent.nodes[len(parent.nodes)-1].ContentObj = top.nodes
+	}
+	return stack[0].nodes
+}
+
+// checkContentHTML fails the test if html has a tag that isn't allowlisted,
+// any attribute but an href or src, or a URL that isn't http or https
+func checkContentHTML(t *testing.T, html string) {
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(html))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == xhtml.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				t.Fatalf("unparsable output %q: %v", html, tokenizer.Err())
+			}
+			return
+		}
+		if tokenType != xhtml.StartTagToken && tokenType != xhtml.EndTagToken && tokenType != xhtml.SelfClosingTagToken {
+			if tokenType != xhtml.TextToken {
+				t.Fatalf("unexpected %v token in %q", tokenType, html)
+			}
+			continue
+		}
+		token := tokenizer.Token()
+		if !allowedContentTags[token.Data] && token.Data != "a" && token.Data != "img" {
+			t.Fatalf("tag %q not allowed in %q", token.Data, html)
+		}
+		for _, attr := range token.Attr {
+			if !(token.Data == "a" && attr.Key == "href") && !(token.Data == "img" && attr.Key == "src") {
+				t.Fatalf("attribute %q on %q not allowed in %q", attr.Key, token.Data, html)
+			}
+			parsed, err := url.Parse(attr.Val)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				t.Fatalf("url %q not allowed in %q", attr.Val, html)
+			}
+		}
+	}
+}
+
+func FuzzBuildContent(f *testing.F) {
+	f.Add([]byte{2, 3, 1, 2, 0, 6}, "p|text|a|img|script|b", `plain|AT&amp;T|<script>alert(1)</script>|https://example.com/x?a=1&b=2|"quoted"`, `https://example.com/a.png|javascript:alert(1)|//cdn.example.com/b.png|data:image/png;base64,AAAA`)
+	f.Add([]byte{1, 1, 1, 1, 1, 1, 1, 1}, `div|SPAN| iframe |a href="x"|img onerror=alert(1)`, `x" onclick="y|&lt;b&gt;|javascript:alert(1)`, `http://example.com/"><script>|ftp://example.com`)
+	f.Add([]byte{}, "", "", "")
+
+	f.Fuzz(func(t *testing.T, shape []byte, types, contents, srcs string) {
+		if len(shape) > 4096 {
+			return
+		}
+		tree := contentTree(shape, strings.Split(types, "|"), strings.Split(contents, "|"), strings.Split(srcs, "|"))
+		checkContentHTML(t, followContent(tree))
+	})
+}
+
+func TestFollowContent(t *testing.T) {
+	tests := []struct {
+		content []msfinance.MSNewsContentObj
+		want    string
+	}{
+		{
+			[]msfinance.MSNewsContentObj{{Type: "p", ContentObj: []msfinance.MSNewsContentObj{{Type: "text", Content: "AT&amp;T & Verizon <up>"}}}},
+			"<p>AT&amp;T &amp; Verizon &lt;up&gt;</p>",
+		},
+		{
+			[]msfinance.MSNewsContentObj{{Type: "a", Content: "https://example.com/?a=1&amp;b=2"}},
+			`<a href="https://example.com/?a=1&amp;b=2">https://example.com/?a=1&amp;b=2</a>`,
+		},
+		{
+			[]msfinance.MSNewsContentObj{{Type: "script", Content: "alert(1)"}, {Type: "img", Src: "javascript:alert(1)"}, {Type: "onclick", Content: "text"}},
+			"text",
+		},
+	}
+	for _, test := range tests {
+		if got := followContent(test.content); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestFollowContentDepth(t *testing.T) {
+	// nested far past maxContentDepth
+	node := msfinance.MSNewsContentObj{Type: "text", Content: "deep"}
+	for i := 0; i < 100000; i++ {
+		node = msfinance.MSNewsContentObj{Type: "span", ContentObj: []msfinance.MSNewsContentObj{node}}
+	}
+	got := followContent([]msfinance.MSNewsContentObj{node})
+	if strings.Contains(got, "deep") {
+		t.Errorf("content past maxContentDepth was kept")
+	}
+	if strings.Count(got, "<span>") != strings.Count(got, "</span>") {
+		t.Errorf("unbalanced output")
+	}
+	checkContentHTML(t, got)
+}
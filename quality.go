@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TickerDailyQuality is a daily bar that failed validation; it is recorded
+// here instead of in ticker_daily so bad provider data doesn't leak into
+// prices, adjustments or gap checks
+type TickerDailyQuality struct {
+	TickerDailyQualityId uint64    `db:"ticker_daily_quality_id"`
+	TickerId             uint64    `db:"ticker_id"`
+	PriceDatetime        time.Time `db:"price_datetime"`
+	Issues               string    `db:"issues"`
+	OpenPrice            float64   `db:"open_price"`
+	HighPrice            float64   `db:"high_price"`
+	LowPrice             float64   `db:"low_price"`
+	ClosePrice           float64   `db:"close_price"`
+	Volume               int64     `db:"volume"`
+	DetectedDatetime     time.Time `db:"detected_datetime"`
+	CreateDatetime       time.Time `db:"create_datetime"`
+	UpdateDatetime       time.Time `db:"update_datetime"`
+}
+
+// the same bar flagged again just gets its values and issues refreshed
+func (tdq *TickerDailyQuality) createOrUpdate(deps *Dependencies) error {
+	db := deps.db
+
+	var insert = "INSERT INTO ticker_daily_quality SET ticker_id=?, price_date=?, price_datetime=?, issues=?, open_price=?, high_price=?, low_price=?, close_price=?, volume=?, detected_datetime=now() ON DUPLICATE KEY UPDATE price_datetime=VALUES(price_datetime), issues=VALUES(issues), open_price=VALUES(open_price), high_price=VALUES(high_price), low_price=VALUES(low_price), close_price=VALUES(close_price), volume=VALUES(volume), detected_datetime=now()"
+	_, err := db.Exec(insert, tdq.TickerId, tdq.PriceDatetime.Format("2006-01-02"), tdq.PriceDatetime, tdq.Issues, tdq.OpenPrice, tdq.HighPrice, tdq.LowPrice, tdq.ClosePrice, tdq.Volume)
+	return err
+}
+
+// getTickerDailyCloseBefore returns the most recent stored close before a
+// day, or 0 if there isn't one
+func getTickerDailyCloseBefore(deps *Dependencies, tickerId uint64, before time.Time) (float64, error) {
+	db := deps.db
+
+	var closePrice float64
+	err := db.QueryRowx("SELECT close_price FROM ticker_daily WHERE ticker_id=? AND price_date < ? ORDER BY price_date DESC LIMIT 1", tickerId, before.Format("2006-01-02")).Scan(&closePrice)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return closePrice, err
+}
+
+// getTickerDailyMovesSinceStored returns the bars before a day that were
+// flagged for nothing but their move and came after the last stored bar;
+// they're validated again with the new batch, which may confirm they were
+// a real change in price level
+func getTickerDailyMovesSinceStored(deps *Dependencies, tickerId uint64, before time.Time) ([]TickerDaily, error) {
+	db := deps.db
+
+	var tickerDailies []TickerDaily
+	var query = `SELECT ticker_id, price_datetime, open_price, high_price, low_price, close_price, volume FROM ticker_daily_quality
+		WHERE ticker_id=? AND price_date < ? AND issues LIKE 'outlier_move(%' AND issues NOT LIKE '%,%'
+		AND price_date > (SELECT COALESCE(MAX(price_date), '1900-01-01') FROM ticker_daily WHERE ticker_id=? AND price_date < ?)
+		ORDER BY price_date`
+	err := db.Select(&tickerDailies, query, tickerId, before.Format("2006-01-02"), tickerId, before.Format("2006-01-02"))
+	return tickerDailies, err
+}
+
+func deleteTickerDailyQuality(deps *Dependencies, tickerId uint64, day time.Time) error {
+	db := deps.db
+
+	_, err := db.Exec("DELETE FROM ticker_daily_quality WHERE ticker_id=? AND price_date=?", tickerId, day.Format("2006-01-02"))
+	return err
+}
+
+// getTickerDailyQualityDates returns the days from start through end that
+// have a flagged bar
+func getTickerDailyQualityDates(deps *Dependencies, tickerId uint64, start, end time.Time) (map[string]bool, error) {
+	db := deps.db
+
+	var dates []string
+	err := db.Select(&dates, "SELECT DATE_FORMAT(price_date, '%Y-%m-%d') FROM ticker_daily_quality WHERE ticker_id=? AND price_date BETWEEN ? AND ?", tickerId, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	flagged := make(map[string]bool, len(dates))
+	for _, date := range dates {
+		flagged[date] = true
+	}
+	return flagged, nil
+}
+
+// validateTickerDaily returns what's wrong with a bar, if anything. prevClose
+// is the close of the session before it (0 if unknown), and a split that day
+// excuses a big move.
+func validateTickerDaily(td TickerDaily, prevClose float64, splitOnDay bool) []string {
+	issues := make([]string, 0)
+
+	if td.OpenPrice < 0 || td.HighPrice < 0 || td.LowPrice < 0 || td.ClosePrice < 0 {
+		issues = append(issues, "negative_price")
+	}
+	if td.HighPrice < math.Max(td.OpenPrice, td.ClosePrice) {
+		issues = append(issues, "high_below_open_close")
+	}
+	if td.LowPrice > math.Min(td.OpenPrice, td.ClosePrice) {
+		issues = append(issues, "low_above_open_close")
+	}
+	if td.Volume == 0 {
+		// ticker_daily won't take it anyway, but we want to know the
+		// provider sent it
+		issues = append(issues, "zero_volume")
+	}
+	if prevClose > 0 && td.ClosePrice > 0 && !splitOnDay && math.Abs(td.ClosePrice/prevClose-1) > eodsOutlierMove {
+		issues = append(issues, fmt.Sprintf("outlier_move(%.1f%%)", (td.ClosePrice/prevClose-1)*100))
+	}
+	return issues
+}
+
+// validateTickerDailies validates a sorted batch of daily bars, returning
+// the issues with each. A bar is compared with the last good close before
+// it, so one bad bar doesn't get the correct one after it flagged too. A bar
+// flagged for nothing but its move is a real change in price level if the
+// next bar agrees with it (a trial result, a buyout, a bankruptcy), in
+// which case both are good and the new level is what later bars are
+// compared with.
+func validateTickerDailies(tickerDailies []TickerDaily, prevClose float64, splitDays map[string]bool) [][]string {
+	allIssues := make([][]string, len(tickerDailies))
+	seen := make(map[string]bool, len(tickerDailies))
+	pending := -1
+	for i, tickerDaily := range tickerDailies {
+		day := tickerDaily.PriceDatetime.Format("2006-01-02")
+
+		issues := validateTickerDaily(tickerDaily, prevClose, splitDays[day])
+		if seen[day] {
+			// keep the first bar we got for a day
+			issues = append(issues, "duplicate_date")
+		}
+		seen[day] = true
+		if pending >= 0 && onlyOutlierMove(issues) && len(validateTickerDaily(tickerDaily, tickerDailies[pending].ClosePrice, splitDays[day])) == 0 {
+			allIssues[pending] = []string{}
+			issues = []string{}
+		}
+		pending = -1
+		if onlyOutlierMove(issues) {
+			pending = i
+		}
+		if len(issues) == 0 && tickerDaily.ClosePrice > 0 {
+			prevClose = tickerDaily.ClosePrice
+		}
+		allIssues[i] = issues
+	}
+	return allIssues
+}
+
+func onlyOutlierMove(issues []string) bool {
+	return len(issues) == 1 && strings.HasPrefix(issues[0], "outlier_move(")
+}
+
+// storeValidTickerDailies validates a batch of daily bars and stores the good
+// ones, flagging the rest in ticker_daily_quality; returns how many were
+// stored
+func storeValidTickerDailies(deps *Dependencies, sublog zerolog.Logger, ticker Ticker, tickerDailies []TickerDaily) (int, error) {
+	if len(tickerDailies) == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(tickerDailies, func(i, j int) bool {
+		return tickerDailies[i].PriceDatetime.Before(tickerDailies[j].PriceDatetime)
+	})
+
+	splits, err := getSplitsByTicker(deps, ticker.TickerId)
+	if err != nil {
+		return 0, err
+	}
+	splitDays := make(map[string]bool, len(splits))
+	for _, split := range splits {
+		splitDays[split.SplitDate.Format("2006-01-02")] = true
+	}
+
+	prevClose, err := getTickerDailyCloseBefore(deps, ticker.TickerId, tickerDailies[0].PriceDatetime)
+	if err != nil {
+		return 0, err
+	}
+	// a move flagged on an earlier run can still be confirmed by this batch
+	moves, err := getTickerDailyMovesSinceStored(deps, ticker.TickerId, tickerDailies[0].PriceDatetime)
+	if err != nil {
+		return 0, err
+	}
+	tickerDailies = append(moves, tickerDailies...)
+	qualityDates, err := getTickerDailyQualityDates(deps, ticker.TickerId, tickerDailies[0].PriceDatetime, tickerDailies[len(tickerDailies)-1].PriceDatetime)
+	if err != nil {
+		return 0, err
+	}
+
+	var lastErr error
+	count := 0
+	flagged := 0
+	allIssues := validateTickerDailies(tickerDailies, prevClose, splitDays)
+	for i, tickerDaily := range tickerDailies {
+		day := tickerDaily.PriceDatetime.Format("2006-01-02")
+
+		issues := allIssues[i]
+		if len(issues) > 0 {
+			flagged++
+			sublog.Warn().Str("price_date", day).Strs("issues", issues).Msg("suspicious daily price for {symbol} on {price_date}, not storing it")
+			quality := TickerDailyQuality{
+				TickerId:      ticker.TickerId,
+				PriceDatetime: tickerDaily.PriceDatetime,
+				Issues:        strings.Join(issues, ","),
+				OpenPrice:     tickerDaily.OpenPrice,
+				HighPrice:     tickerDaily.HighPrice,
+				LowPrice:      tickerDaily.LowPrice,
+				ClosePrice:    tickerDaily.ClosePrice,
+				Volume:        tickerDaily.Volume,
+			}
+			if err := quality.createOrUpdate(deps); err != nil {
+				lastErr = err
+			}
+			continue
+		}
+
+		err := tickerDaily.createOrUpdate(deps)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if qualityDates[day] {
+			// flagged before, good now
+			if err := deleteTickerDailyQuality(deps, ticker.TickerId, tickerDaily.PriceDatetime); err != nil {
+				lastErr = err
+			}
+		}
+		count++
+	}
+	if flagged > 0 {
+		sublog.Info().Int("flagged", flagged).Msg("flagged {flagged} daily prices for {symbol}")
+	}
+	return count, lastErr
+}
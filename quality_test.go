@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func dailyBar(day int, open, high, low, close float64) TickerDaily {
+	return TickerDaily{
+		PriceDatetime: time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC),
+		OpenPrice:     open,
+		HighPrice:     high,
+		LowPrice:      low,
+		ClosePrice:    close,
+		Volume:        1000,
+	}
+}
+
+func TestValidateTickerDaily(t *testing.T) {
+	tests := []struct {
+		name       string
+		bar        TickerDaily
+		prevClose  float64
+		splitOnDay bool
+		want       []string
+	}{
+		{"good", dailyBar(4, 10, 11, 9, 10.5), 10, false, []string{}},
+		{"no previous close", dailyBar(4, 10, 11, 9, 10.5), 0, false, []string{}},
+		{"negative", dailyBar(4, -1, 11, -2, 10), 10, false, []string{"negative_price"}},
+		{"high below close", dailyBar(4, 10, 10.2, 9, 10.5), 10, false, []string{"high_below_open_close"}},
+		{"low above open", dailyBar(4, 9, 11, 9.5, 10.5), 10, false, []string{"low_above_open_close"}},
+		{"spike", dailyBar(4, 100, 100, 100, 100), 10, false, []string{"outlier_move(900.0%)"}},
+		{"drop", dailyBar(4, 4, 4, 4, 4), 10, false, []string{"outlier_move(-60.0%)"}},
+		{"split excuses the move", dailyBar(4, 5, 5.5, 4.5, 5), 10, true, []string{}},
+		{"no volume", TickerDaily{PriceDatetime: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), OpenPrice: 10, HighPrice: 10, LowPrice: 10, ClosePrice: 10}, 10, false, []string{"zero_volume"}},
+	}
+	for _, test := range tests {
+		if got := validateTickerDaily(test.bar, test.prevClose, test.splitOnDay); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidateTickerDailies(t *testing.T) {
+	tests := []struct {
+		name      string
+		bars      []TickerDaily
+		prevClose float64
+		splitDays map[string]bool
+		want      [][]string
+	}{
+		{
+			"spike doesn't become the baseline",
+			[]TickerDaily{dailyBar(4, 10, 10, 10, 10), dailyBar(5, 100, 100, 100, 100), dailyBar(6, 10, 10, 10, 10)},
+			0, nil,
+			[][]string{{}, {"outlier_move(900.0%)"}, {}},
+		},
+		{
+			"spike against the stored close",
+			[]TickerDaily{dailyBar(5, 100, 100, 100, 100), dailyBar(6, 10.2, 10.2, 10.2, 10.2)},
+			10, nil,
+			[][]string{{"outlier_move(900.0%)"}, {}},
+		},
+		{
+			"next bar confirms a new level",
+			[]TickerDaily{dailyBar(4, 10, 10, 10, 10), dailyBar(5, 100, 100, 100, 100), dailyBar(6, 101, 101, 101, 101), dailyBar(7, 102, 102, 102, 102)},
+			0, nil,
+			[][]string{{}, {}, {}, {}},
+		},
+		{
+			"new level against the stored close",
+			[]TickerDaily{dailyBar(5, 100, 100, 100, 100), dailyBar(6, 101, 101, 101, 101)},
+			10, nil,
+			[][]string{{}, {}},
+		},
+		{
+			"two spikes don't confirm each other",
+			[]TickerDaily{dailyBar(4, 10, 10, 10, 10), dailyBar(5, 100, 100, 100, 100), dailyBar(6, 1, 1, 1, 1)},
+			0, nil,
+			[][]string{{}, {"outlier_move(900.0%)"}, {"outlier_move(-90.0%)"}},
+		},
+		{
+			"split becomes the baseline",
+			[]TickerDaily{dailyBar(4, 10, 10, 10, 10), dailyBar(5, 5, 5, 5, 5), dailyBar(6, 5.1, 5.1, 5.1, 5.1)},
+			0, map[string]bool{"2024-03-05": true},
+			[][]string{{}, {}, {}},
+		},
+		{
+			"duplicate day",
+			[]TickerDaily{dailyBar(4, 10, 10, 10, 10), dailyBar(4, 10, 10, 10, 10)},
+			0, nil,
+			[][]string{{}, {"duplicate_date"}},
+		},
+	}
+	for _, test := range tests {
+		if got := validateTickerDailies(test.bars, test.prevClose, test.splitDays); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+)
+
+const (
+	schedulerLockName       = "stockwatch-pqms-scheduler"
+	schedulerScanDelay      = 5   // minutes between scans
+	schedulerMaxPerScan     = 500 // tickers per activity per scan
+	schedulerEnqueueRate    = 5   // tasks per second
+	schedulerTickersPerTask = 25  // tickers per enqueued task
+)
+
+// a per-ticker action the scheduler keeps fresh, by the lastdone activity
+// its handler records and the same window the handler uses to skip work;
+// failedDelay, if set, is a shorter window for when the last try failed
+type scheduledActivity struct {
+	action      string
+	activity    string
+	delay       time.Duration
+	failedDelay time.Duration
+}
+
+var (
+	scheduledActivities = []scheduledActivity{
+		{"eods", "ticker_eods", minTickerEODsDelay * time.Minute, 0},
+		{"news", "ticker_news", minTickerNewsDelay * time.Minute, 0},
+		{"financials", "ticker_financials", minTickerFinancialsDelay * time.Minute, 0},
+		// a ticker without a website yet usually gets one from its profile
+		{"favicon", "ticker_favicon", minTickerFavIconDelay * time.Minute, minTickerFavIconRetryDelay * time.Minute},
+		{"profile", "ticker_profile", minTickerProfileDelay * time.Minute, 0},
+		{"gaps", "ticker_gaps", minTickerGapsDelay * time.Minute, 0},
+		{"earnings", "ticker_earnings", minTickerEarningsDelay * time.Minute, 0},
+	}
+)
+
+type staleTicker struct {
+	TickerId     uint64 `db:"ticker_id"`
+	TickerSymbol string `db:"ticker_symbol"`
+	ExchangeId   uint64 `db:"exchange_id"`
+}
+
+// runScheduler runs forever, but only schedules while it holds the
+// scheduler lock, so any number of instances can run it and just one will
+// be enqueueing at a time
+func runScheduler(deps *Dependencies) error {
+	sublog := deps.logger.With().Str("mode", "scheduler").Logger()
+
+	// what we enqueued and when, so we don't pile up duplicate tasks while
+	// the queue works through them
+	enqueued := make(map[string]time.Time)
+
+	sublog.Info().Msg("starting up pqms scheduler")
+	for {
+		conn, err := acquireSchedulerLock(deps)
+		if err != nil {
+			sublog.Error().Err(err).Msg("failed to check scheduler lock")
+		} else if conn == nil {
+			sublog.Debug().Msg("another instance holds the scheduler lock")
+		} else {
+			sublog.Info().Msg("acquired scheduler lock, this instance is scheduling")
+			for holdsSchedulerLock(conn) {
+				scheduleStaleTasks(deps, sublog, enqueued)
+				time.Sleep(schedulerScanDelay * time.Minute)
+			}
+			sublog.Warn().Msg("lost scheduler lock")
+			conn.Close()
+		}
+		time.Sleep(schedulerScanDelay * time.Minute)
+	}
+}
+
+// acquireSchedulerLock takes a MySQL named lock on its own connection, which
+// is held for as long as that connection stays open; returns a nil conn if
+// someone else has it
+func acquireSchedulerLock(deps *Dependencies) (*sqlx.Conn, error) {
+	db := deps.db
+
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired int
+	err = conn.QueryRowxContext(ctx, "SELECT COALESCE(GET_LOCK(?, 0), 0)", schedulerLockName).Scan(&acquired)
+	if err != nil || acquired != 1 {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func holdsSchedulerLock(conn *sqlx.Conn) bool {
+	var holding int
+	err := conn.QueryRowxContext(context.Background(), "SELECT COALESCE(IS_USED_LOCK(?) = CONNECTION_ID(), 0)", schedulerLockName).Scan(&holding)
+	return err == nil && holding == 1
+}
+
+// scheduleStaleTasks enqueues a task for every ticker whose lastdone for an
+// activity is missing or older than its freshness window, no faster than
+// schedulerEnqueueRate
+func scheduleStaleTasks(deps *Dependencies, sublog zerolog.Logger, enqueued map[string]time.Time) {
+	throttle := time.NewTicker(time.Second / schedulerEnqueueRate)
+	defer throttle.Stop()
+
+	for _, activity := range scheduledActivities {
+		failedDelay := activity.failedDelay
+		if failedDelay == 0 {
+			failedDelay = activity.delay
+		}
+		tickers, err := getStaleTickers(deps, activity.activity, time.Now().Add(-activity.delay), time.Now().Add(-failedDelay))
+		if err != nil {
+			sublog.Error().Err(err).Str("activity", activity.activity).Msg("failed to find stale tickers for {activity}")
+			continue
+		}
+		count := enqueueTickerTasks(deps, sublog, throttle, activity, tickers, enqueued)
+		sublog.Info().Str("action", activity.action).Int("count", count).Msg("scheduled {action} for {count} tickers")
+	}
+
+	// financials don't wait for their usual window when a company has just
+	// reported earnings
+	tickers, err := getTickersReportedSinceFinancials(deps)
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to find tickers that reported earnings")
+	} else {
+		activity := scheduledActivity{"financials", "ticker_financials", minTickerFinancialsDelay * time.Minute, 0}
+		count := enqueueTickerTasks(deps, sublog, throttle, activity, tickers, enqueued)
+		sublog.Info().Int("count", count).Msg("scheduled financials for {count} tickers that reported earnings")
+	}
+
+	// feeds aren't per ticker, one task reads all of them
+	if when, ok := enqueued["feeds"]; !ok || when.Add(minFeedDelay*time.Minute).Before(time.Now()) {
+		<-throttle.C
+		err := enqueueTask(deps, tickersQueueName, "feeds", TaskFeedsBody{})
+		if err != nil {
+			sublog.Error().Err(err).Msg("failed to enqueue feeds")
+		} else {
+			enqueued["feeds"] = time.Now()
+			sublog.Info().Msg("scheduled feeds")
+		}
+	}
+
+	// forget anything old enough that it would be stale again anyway
+	for key, when := range enqueued {
+		if when.Add(minTickerFavIconDelay * time.Minute).Before(time.Now()) {
+			delete(enqueued, key)
+		}
+	}
+}
+
+// enqueueTickerTasks batches tickers up into tasks for an activity, since
+// the handlers fan out over a list of tickers, skipping any we enqueued
+// recently; returns how many tickers were enqueued
+func enqueueTickerTasks(deps *Dependencies, sublog zerolog.Logger, throttle *time.Ticker, activity scheduledActivity, tickers []staleTicker, enqueued map[string]time.Time) int {
+	batch := make([]TaskTicker, 0, schedulerTickersPerTask)
+	count := 0
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		<-throttle.C
+		err := enqueueTask(deps, tickersQueueName, activity.action, TaskTickers{Tickers: batch})
+		if err != nil {
+			sublog.Error().Err(err).Str("action", activity.action).Int("tickers", len(batch)).Msg("failed to enqueue {action} for {tickers} tickers")
+		} else {
+			for _, taskTicker := range batch {
+				enqueued[activity.action+"/"+taskTicker.TickerSymbol] = time.Now()
+			}
+			count += len(batch)
+		}
+		batch = make([]TaskTicker, 0, schedulerTickersPerTask)
+	}
+	for _, ticker := range tickers {
+		key := activity.action + "/" + ticker.TickerSymbol
+		if when, ok := enqueued[key]; ok && when.Add(activity.delay).After(time.Now()) {
+			continue
+		}
+		batch = append(batch, TaskTicker{TickerId: ticker.TickerId, TickerSymbol: ticker.TickerSymbol, ExchangeId: ticker.ExchangeId})
+		if len(batch) == schedulerTickersPerTask {
+			flush()
+		}
+	}
+	flush()
+	return count
+}
+
+// getStaleTickers returns tickers never done for an activity, last done
+// before the cutoff, or last tried and failed before failedCutoff, oldest
+// first
+func getStaleTickers(deps *Dependencies, activity string, cutoff, failedCutoff time.Time) ([]staleTicker, error) {
+	db := deps.db
+
+	var tickers []staleTicker
+	var query = `SELECT ticker.ticker_id, ticker.ticker_symbol, ticker.exchange_id
+		FROM ticker
+		LEFT JOIN lastdone ON (lastdone.activity=? AND lastdone.unique_key=ticker.ticker_symbol)
+		WHERE lastdone.lastdone_datetime IS NULL OR lastdone.lastdone_datetime < ?
+		OR (lastdone.last_status != 'success' AND lastdone.lastdone_datetime < ?)
+		ORDER BY lastdone.lastdone_datetime
+		LIMIT ?`
+	err := db.Select(&tickers, query, activity, cutoff, failedCutoff, schedulerMaxPerScan)
+	return tickers, err
+}
+
+// getTickersReportedSinceFinancials returns tickers that reported earnings in
+// the last few days but whose financials were last done before the report
+// was out, going by the close on the report date in the exchange's timezone
+func getTickersReportedSinceFinancials(deps *Dependencies) ([]staleTicker, error) {
+	db := deps.db
+
+	var reports []struct {
+		staleTicker
+		EarningsDate     time.Time    `db:"earnings_date"`
+		LastdoneDatetime sql.NullTime `db:"lastdone_datetime"`
+	}
+	var query = `SELECT ticker.ticker_id, ticker.ticker_symbol, ticker.exchange_id, ticker_earnings.earnings_date, lastdone.lastdone_datetime
+		FROM ticker_earnings
+		JOIN ticker ON (ticker.ticker_id=ticker_earnings.ticker_id)
+		LEFT JOIN lastdone ON (lastdone.activity='ticker_financials' AND lastdone.unique_key=ticker.ticker_symbol)
+		WHERE ticker_earnings.earnings_date BETWEEN ? AND ?
+		ORDER BY ticker_earnings.earnings_date`
+	// a day either side, the dates are the exchange's and not ours
+	now := time.Now()
+	err := db.Select(&reports, query, now.AddDate(0, 0, -earningsFinancialsLookback-1).Format("2006-01-02"), now.AddDate(0, 0, 1).Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	tickers := make([]staleTicker, 0)
+	seen := make(map[uint64]bool)
+	for _, report := range reports {
+		if seen[report.TickerId] || len(tickers) >= schedulerMaxPerScan {
+			continue
+		}
+		available := getTradingCalendar(deps, report.ExchangeId).financialsAvailableSince(report.EarningsDate)
+		if now.Before(available) || now.After(available.AddDate(0, 0, earningsFinancialsLookback)) {
+			continue
+		}
+		if report.LastdoneDatetime.Valid && !report.LastdoneDatetime.Time.Before(available) {
+			continue
+		}
+		seen[report.TickerId] = true
+		tickers = append(tickers, report.staleTicker)
+	}
+	return tickers, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	searchMinTermLength   = 2   // shorter words aren't indexed
+	searchMaxTermLength   = 64  // longer "words" are noise, and don't fit the column
+	searchTitleWeight     = 3   // a term in the title counts this many times one in the body
+	searchDefaultLimit    = 20  // results when no limit is given
+	searchMaxLimit        = 500 // most results one search returns
+	searchPhraseOverfetch = 5   // candidates fetched at a time per result wanted, when phrases still have to be checked
+	searchPhraseMaxPages  = 4   // pages of candidates checked for phrases before we settle for what we found
+)
+
+var (
+	searchQueryParts = regexp.MustCompile(`"([^"]*)"|(\S+)`)
+
+	// words too common to narrow anything down; they're still matched as
+	// part of a quoted phrase
+	searchStopWords = lexicon(`
+		a an and are as at be by for from has have in is it its of on or that the this to was were will with
+	`)
+)
+
+// ArticleSearch is a query against the article index: every term must
+// match, every phrase must appear as written, and the facets narrow it to
+// a ticker and a published date range
+type ArticleSearch struct {
+	Query        string
+	TickerSymbol string
+	From         time.Time
+	Until        time.Time
+	Limit        int
+}
+
+type ArticleSearchResult struct {
+	ArticleId         uint64       `db:"article_id"`
+	PublishedDatetime sql.NullTime `db:"published_datetime"`
+	Title             string       `db:"title"`
+	Summary           string       `db:"summary"`
+	ArticleURL        string       `db:"article_url"`
+	BodyText          string       `db:"body_text"`
+	Score             float64      `db:"score"`
+}
+
+// searchTerms reduces text to its indexable words, in order
+func searchTerms(text string) []string {
+	words := strings.Fields(normalizeArticleTitle(text))
+	terms := words[:0]
+	for _, word := range words {
+		if len(word) < searchMinTermLength || len(word) > searchMaxTermLength || searchStopWords[word] {
+			continue
+		}
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// indexArticle replaces an article's entries in the search index with one
+// row per term, counting how often it's in the title and in the body
+func indexArticle(deps *Dependencies, article *Article) error {
+	db := deps.db
+
+	type termCounts struct{ title, body int }
+	counts := make(map[string]*termCounts)
+	var order []string
+	count := func(text string, inTitle bool) {
+		for _, term := range searchTerms(text) {
+			tc, ok := counts[term]
+			if !ok {
+				tc = &termCounts{}
+				counts[term] = tc
+				order = append(order, term)
+			}
+			if inTitle {
+				tc.title++
+			} else {
+				tc.body++
+			}
+		}
+	}
+	count(article.Title, true)
+	bodyText := article.BodyText
+	if bodyText == "" {
+		bodyText = articleText(article.Body)
+	}
+	count(bodyText, false)
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM article_term WHERE article_id=?", article.ArticleId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	insert, err := tx.Prepare("INSERT INTO article_term SET article_id=?, term=?, title_count=?, body_count=?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer insert.Close()
+
+	for _, term := range order {
+		_, err := insert.Exec(article.ArticleId, term, counts[term].title, counts[term].body)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// parseSearchQuery splits a query into the terms to look up in the index
+// and the "quoted phrases" to check in what it finds
+func parseSearchQuery(query string) ([]string, []string) {
+	var terms, phrases []string
+	seen := make(map[string]bool)
+	for _, match := range searchQueryParts.FindAllStringSubmatch(query, -1) {
+		text := match[2]
+		if match[1] != "" {
+			text = match[1]
+			if phrase := normalizeArticleTitle(text); strings.Contains(phrase, " ") {
+				phrases = append(phrases, phrase)
+			}
+		}
+		for _, term := range searchTerms(text) {
+			if !seen[term] {
+				seen[term] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms, phrases
+}
+
+// matchesPhrases reports whether every phrase is in the title or the body
+func (r ArticleSearchResult) matchesPhrases(phrases []string) bool {
+	title := " " + normalizeArticleTitle(r.Title) + " "
+	body := " " + normalizeArticleTitle(r.BodyText) + " "
+	for _, phrase := range phrases {
+		if !strings.Contains(title, " "+phrase+" ") && !strings.Contains(body, " "+phrase+" ") {
+			return false
+		}
+	}
+	return true
+}
+
+// searchArticles finds the articles matching a search, best first: terms in
+// the title count for more, and ties go to the most recent. The index
+// finds the articles with every term; those are then paged through,
+// checking for the phrases, until there are enough results or no more
+// candidates. Only searchPhraseMaxPages pages are checked, so a phrase
+// that's rare among them can come up short; incomplete says so.
+func searchArticles(deps *Dependencies, search ArticleSearch) (results []ArticleSearchResult, incomplete bool, err error) {
+	db := deps.db
+
+	terms, phrases := parseSearchQuery(search.Query)
+	if len(terms) == 0 {
+		return nil, false, fmt.Errorf("nothing to search for in %q", search.Query)
+	}
+	limit := search.Limit
+	if limit <= 0 {
+		limit = searchDefaultLimit
+	}
+	if limit > searchMaxLimit {
+		limit = searchMaxLimit
+	}
+	fetch := limit
+	if len(phrases) > 0 {
+		fetch = limit * searchPhraseOverfetch
+	}
+
+	query := "SELECT article.article_id, article.published_datetime, article.title, article.summary, article.article_url, article.body_text, SUM(article_term.title_count*? + article_term.body_count) AS score FROM article_term JOIN article ON (article.article_id=article_term.article_id)"
+	args := []interface{}{searchTitleWeight}
+	if search.TickerSymbol != "" {
+		query += " JOIN article_ticker ON (article_ticker.article_id=article.article_id AND article_ticker.ticker_symbol=?)"
+		args = append(args, search.TickerSymbol)
+	}
+	query += " WHERE article_term.term IN (?)"
+	args = append(args, terms)
+	if !search.From.IsZero() {
+		query += " AND article.published_datetime >= ?"
+		args = append(args, search.From)
+	}
+	if !search.Until.IsZero() {
+		query += " AND article.published_datetime < ?"
+		args = append(args, search.Until)
+	}
+	query += " GROUP BY article.article_id HAVING COUNT(DISTINCT article_term.term)=? ORDER BY score DESC, article.published_datetime DESC, article.article_id DESC LIMIT ? OFFSET ?"
+	args = append(args, len(terms), fetch)
+
+	query, args, err = sqlx.In(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	query = db.Rebind(query)
+
+	results = make([]ArticleSearchResult, 0, limit)
+	for page := 0; ; page++ {
+		if page == searchPhraseMaxPages {
+			return results, true, nil
+		}
+		var candidates []ArticleSearchResult
+		err = db.Select(&candidates, query, append(args, page*fetch)...)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, candidate := range candidates {
+			if candidate.matchesPhrases(phrases) {
+				results = append(results, candidate)
+				if len(results) == limit {
+					return results, false, nil
+				}
+			}
+		}
+		if len(candidates) < fetch {
+			return results, false, nil
+		}
+	}
+}
+
+// articleSearchResponse is what the search API returns; Incomplete means
+// there may be more matches for its phrases than were found
+type articleSearchResponse struct {
+	Results    []articleSearchResult `json:"results"`
+	Incomplete bool                  `json:"incomplete"`
+}
+
+// articleSearchResult is a search result as the search API returns it
+type articleSearchResult struct {
+	ArticleId  uint64  `json:"article_id"`
+	Published  string  `json:"published,omitempty"`
+	Title      string  `json:"title"`
+	Summary    string  `json:"summary"`
+	ArticleURL string  `json:"article_url"`
+	Score      float64 `json:"score"`
+}
+
+// parseArticleSearch reads a search from query parameters: q, and
+// optionally ticker, from and until (YYYY-MM-DD) and limit
+func parseArticleSearch(values url.Values) (ArticleSearch, error) {
+	search := ArticleSearch{Query: values.Get("q"), TickerSymbol: strings.ToUpper(values.Get("ticker"))}
+	var err error
+	if search.From, err = parseSearchDate(values.Get("from")); err != nil {
+		return search, err
+	}
+	if search.Until, err = parseSearchDate(values.Get("until")); err != nil {
+		return search, err
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if search.Limit, err = strconv.Atoi(limit); err != nil {
+			return search, fmt.Errorf("bad limit %s", limit)
+		}
+	}
+	return search, nil
+}
+
+// parseSearchDate parses a YYYY-MM-DD date facet, or returns a zero time
+// for no date
+func parseSearchDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return parsed, fmt.Errorf("bad date %s", date)
+	}
+	return parsed, nil
+}
+
+// searchHandler answers GET /search?q=...&ticker=...&from=...&until=...&limit=...
+// with the matching articles as JSON
+func searchHandler(deps *Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sublog := deps.logger
+
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		search, err := parseArticleSearch(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(searchTerms(search.Query)) == 0 {
+			http.Error(w, "nothing to search for", http.StatusBadRequest)
+			return
+		}
+
+		results, incomplete, err := searchArticles(deps, search)
+		if err != nil {
+			sublog.Error().Err(err).Str("query", search.Query).Msg("search for {query} failed")
+			http.Error(w, "search failed", http.StatusInternalServerError)
+			return
+		}
+		response := articleSearchResponse{Results: make([]articleSearchResult, 0, len(results)), Incomplete: incomplete}
+		for _, result := range results {
+			item := articleSearchResult{ArticleId: result.ArticleId, Title: result.Title, Summary: result.Summary, ArticleURL: result.ArticleURL, Score: result.Score}
+			if result.PublishedDatetime.Valid {
+				item.Published = result.PublishedDatetime.Time.Format(time.RFC3339)
+			}
+			response.Results = append(response.Results, item)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
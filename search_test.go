@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		terms   []string
+		phrases []string
+	}{
+		{`guidance cut`, []string{"guidance", "cut"}, nil},
+		{`"guidance cut"`, []string{"guidance", "cut"}, []string{"guidance cut"}},
+		{`AAPL "cut the guidance" it's`, []string{"aapl", "cut", "guidance"}, []string{"cut the guidance"}},
+		{`"Q3" "Q3" q3`, []string{"q3"}, nil},
+		{`the of "a"`, nil, nil},
+	}
+	for _, test := range tests {
+		terms, phrases := parseSearchQuery(test.query)
+		if !reflect.DeepEqual(terms, test.terms) || !reflect.DeepEqual(phrases, test.phrases) {
+			t.Errorf("%s: got %q %q, want %q %q", test.query, terms, phrases, test.terms, test.phrases)
+		}
+	}
+}
+
+func TestMatchesPhrases(t *testing.T) {
+	result := ArticleSearchResult{
+		Title:    "Acme Cuts Guidance",
+		BodyText: "The company's guidance cut, its second this year, sent shares lower. Costs cut guidance-wise.",
+	}
+	tests := []struct {
+		phrases []string
+		want    bool
+	}{
+		{nil, true},
+		{[]string{"guidance cut"}, true},
+		{[]string{"cuts guidance"}, true},
+		{[]string{"cut guidance"}, true},
+		{[]string{"guidance cut", "second this year"}, true},
+		{[]string{"guidance cuts"}, false},
+		{[]string{"guidance cut", "third this year"}, false},
+		// phrases match whole words only
+		{[]string{"idance cut"}, false},
+	}
+	for _, test := range tests {
+		if got := result.matchesPhrases(test.phrases); got != test.want {
+			t.Errorf("%q: got %v, want %v", test.phrases, got, test.want)
+		}
+	}
+}
+
+func TestParseArticleSearch(t *testing.T) {
+	values := url.Values{"q": {`"guidance cut"`}, "ticker": {"aapl"}, "from": {"2024-01-01"}, "until": {"2024-04-01"}, "limit": {"5"}}
+	search, err := parseArticleSearch(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ArticleSearch{
+		Query:        `"guidance cut"`,
+		TickerSymbol: "AAPL",
+		From:         time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+		Until:        time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local),
+		Limit:        5,
+	}
+	if !reflect.DeepEqual(search, want) {
+		t.Errorf("got %+v, want %+v", search, want)
+	}
+
+	for _, bad := range []url.Values{{"q": {"x"}, "from": {"yesterday"}}, {"q": {"x"}, "limit": {"ten"}}} {
+		if _, err := parseArticleSearch(bad); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+}
+
+func TestSearchHandlerBadRequests(t *testing.T) {
+	handler := searchHandler(&Dependencies{})
+	tests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodGet, "/search", http.StatusBadRequest},
+		{http.MethodGet, "/search?q=the", http.StatusBadRequest},
+		{http.MethodGet, "/search?q=cut&from=soon", http.StatusBadRequest},
+		{http.MethodPost, "/search?q=cut", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(test.method, test.target, nil))
+		if recorder.Code != test.status {
+			t.Errorf("%s %s: got %d, want %d", test.method, test.target, recorder.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	sentimentNegationWindow = 3   // words after a negator whose tone is flipped
+	sentimentNeutralBand    = 0.1 // scores within this of zero count as neither positive nor negative
+)
+
+var (
+	sentenceSplit = regexp.MustCompile(`[.!?]+\s+|\n+`)
+
+	// a small finance lexicon, in the spirit of Loughran-McDonald: words
+	// that read as good or bad news in the context of a company or stock.
+	// Words that turn up in boilerplate whatever the news (risk factors,
+	// dividend notices, analyst buy/sell ratings, prices moving higher or
+	// lower) are left out.
+	positiveWords = lexicon(`
+		beat beats beating exceeded exceeds exceed outperform outperformed outperforms
+		gain gains gained rally rallied rallies surge surged surges soar soared soars jump jumped jumps
+		rise rises rising rose climb climbed climbs rebound rebounded record strong stronger strongest
+		growth grow grew grows expand expanded expansion profit profits profitable profitability
+		upgrade upgraded upgrades bullish optimistic optimism positive boost boosted boosts
+		improve improved improves improvement raise raised raises top tops topped
+		win wins won award awarded approval approved approves breakthrough innovative success successful
+		buyback buybacks repurchase momentum robust solid healthy upbeat confident
+		accelerate accelerated accelerating efficient leading leader exceeding favorable upside
+	`)
+	negativeWords = lexicon(`
+		miss missed misses missing underperform underperformed underperforms disappoint disappointed
+		disappointing disappoints loss losses lose losing lost decline declined declines declining
+		fall falls fell falling drop dropped drops plunge plunged plunges slump slumped slumps tumble
+		tumbled tumbles sink sank sinks crash crashed slide slid weak weaker weakest weakness
+		downgrade downgraded downgrades bearish pessimistic negative cut cuts cutting slash slashed
+		warn warned warning warns concern concerns worried worries fear fears
+		lawsuit lawsuits sued litigation investigation probe fraud recall recalled layoff layoffs
+		bankruptcy bankrupt default defaulted impairment writedown restructuring delay delayed
+		halt halted suspend suspended fined penalty penalties violation breach shortfall volatile
+		downturn slowdown struggle struggled struggles struggling headwinds downside adverse
+	`)
+	negators = lexicon(`not no never without neither nor hardly barely isn't wasn't didn't doesn't don't won't can't couldn't`)
+)
+
+func lexicon(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// sentimentCounts are the positive and negative words found in some text
+type sentimentCounts struct {
+	positive int
+	negative int
+}
+
+// score is between -1 and 1, pulled toward 0 when there are only a few
+// tone words to go on
+func (sc sentimentCounts) score() float64 {
+	return float64(sc.positive-sc.negative) / float64(sc.positive+sc.negative+2)
+}
+
+// countSentiment counts tone words, flipping any that closely follow a
+// negator ("did not beat")
+func countSentiment(text string) sentimentCounts {
+	var counts sentimentCounts
+	negatedUntil := -1
+	words := strings.Fields(strings.ToLower(text))
+	for i, word := range words {
+		word = strings.Trim(word, `.,;:!?"()[]{}`+"'`")
+		if negators[word] {
+			negatedUntil = i + sentimentNegationWindow
+			continue
+		}
+		positive, negative := positiveWords[word], negativeWords[word]
+		if i <= negatedUntil {
+			positive, negative = negative, positive
+		}
+		if positive {
+			counts.positive++
+		} else if negative {
+			counts.negative++
+		}
+	}
+	return counts
+}
+
+// tickerSentiment scores an article for one ticker from the sentences that
+// mention it, falling back to the whole article if none do; the title
+// counts twice, it's what most readers take away
+func tickerSentiment(title, text string, ticker entityTicker) sentimentCounts {
+	keys := []string{}
+	for _, name := range []string{ticker.CompanyName, ticker.TickerName} {
+		if key := companyNameKey(name); len(key) >= 4 {
+			keys = append(keys, key)
+		}
+	}
+	mentions := func(sentence string) bool {
+		// the symbol as written, so ON or LOW isn't found in every sentence
+		if mentionsSymbol(sentence, ticker.TickerSymbol) {
+			return true
+		}
+		normalized := " " + normalizeArticleTitle(sentence) + " "
+		for _, key := range keys {
+			if strings.Contains(normalized, " "+key+" ") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var counts, all sentimentCounts
+	add := func(to *sentimentCounts, from sentimentCounts, times int) {
+		to.positive += from.positive * times
+		to.negative += from.negative * times
+	}
+	for i, sentence := range append([]string{title}, sentenceSplit.Split(text, -1)...) {
+		// by position, a body sentence that repeats the title is still
+		// only counted once
+		times := 1
+		if i == 0 {
+			times = 2
+		}
+		sentenceCounts := countSentiment(sentence)
+		add(&all, sentenceCounts, times)
+		if mentions(sentence) {
+			add(&counts, sentenceCounts, times)
+		}
+	}
+	if counts.positive+counts.negative == 0 {
+		return all
+	}
+	return counts
+}
+
+// scoreArticleSentiment scores an article for every ticker linked to it and
+// refreshes those tickers' daily sentiment for the day it was published
+func scoreArticleSentiment(deps *Dependencies, sublog zerolog.Logger, article Article) error {
+	db := deps.db
+
+	var articleTickers []ArticleTicker
+	err := db.Select(&articleTickers, "SELECT * FROM article_ticker WHERE article_id=?", article.ArticleId)
+	if err != nil {
+		return err
+	}
+	index, err := getEntityIndex(deps)
+	if err != nil {
+		return err
+	}
+
+	text := article.BodyText
+	if text == "" {
+		text = articleText(article.Body)
+	}
+	for _, articleTicker := range articleTickers {
+		ticker, ok := index.bySymbol[articleTicker.TickerSymbol]
+		if !ok {
+			ticker = entityTicker{TickerId: articleTicker.TickerId, TickerSymbol: articleTicker.TickerSymbol}
+		}
+		counts := tickerSentiment(article.Title, text, ticker)
+		_, err := db.Exec("UPDATE article_ticker SET sentiment_score=?, positive_words=?, negative_words=? WHERE article_ticker_id=?", counts.score(), counts.positive, counts.negative, articleTicker.ArticleTickerId)
+		if err != nil {
+			return err
+		}
+
+		if article.PublishedDatetime.Valid {
+			err := updateTickerSentimentDaily(deps, articleTicker.TickerId, article.PublishedDatetime.Time)
+			if err != nil {
+				sublog.Warn().Err(err).Str("ticker", articleTicker.TickerSymbol).Msg("failed to update daily sentiment")
+			}
+		}
+	}
+	return nil
+}
+
+// updateTickerSentimentDaily recomputes a ticker's sentiment for one day
+// from every scored article published that day, averaged plainly and
+// weighted by how sure we are the article is about the ticker. Days are cut
+// in exchange time, like ticker_daily, whatever zone the source gave us.
+func updateTickerSentimentDaily(deps *Dependencies, tickerId uint64, day time.Time) error {
+	db := deps.db
+
+	day = day.In(newYork)
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, newYork)
+	var upsert = `INSERT INTO ticker_sentiment_daily (ticker_id, sentiment_date, article_count, positive_count, negative_count, avg_score, weighted_score)
+		SELECT article_ticker.ticker_id, ?, COUNT(*), SUM(article_ticker.sentiment_score > ?), SUM(article_ticker.sentiment_score < ?), AVG(article_ticker.sentiment_score), SUM(article_ticker.sentiment_score*article_ticker.confidence)/SUM(article_ticker.confidence)
+		FROM article_ticker
+		JOIN article ON (article.article_id=article_ticker.article_id)
+		WHERE article_ticker.ticker_id=? AND article_ticker.sentiment_score IS NOT NULL
+		AND article.published_datetime >= ? AND article.published_datetime < ?
+		GROUP BY article_ticker.ticker_id
+		ON DUPLICATE KEY UPDATE article_count=VALUES(article_count), positive_count=VALUES(positive_count), negative_count=VALUES(negative_count), avg_score=VALUES(avg_score), weighted_score=VALUES(weighted_score)`
+	_, err := db.Exec(upsert, start.Format("2006-01-02"), sentimentNeutralBand, -sentimentNeutralBand, tickerId, start, start.AddDate(0, 0, 1))
+	return err
+}
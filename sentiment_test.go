@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestCountSentiment(t *testing.T) {
+	tests := []struct {
+		text string
+		want sentimentCounts
+	}{
+		{"Acme beat estimates and raised guidance.", sentimentCounts{positive: 2}},
+		{"Acme did not beat estimates.", sentimentCounts{negative: 1}},
+		{"No losses this quarter.", sentimentCounts{positive: 1}},
+		{"Acme didn't miss, and it wasn't a weak quarter.", sentimentCounts{positive: 2}},
+		// the negation only reaches a few words
+		{"Not that anyone expected it, but margins were stronger.", sentimentCounts{positive: 1}},
+		// boilerplate isn't news
+		{"Risk factors include debt; the dividend is payable to holders. Analysts rate it a buy, shares were higher.", sentimentCounts{}},
+	}
+	for _, test := range tests {
+		if got := countSentiment(test.text); got != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestTickerSentiment(t *testing.T) {
+	ticker := entityTicker{TickerId: 1, TickerSymbol: "ACME", CompanyName: "Acme Corporation"}
+	tests := []struct {
+		name  string
+		title string
+		text  string
+		want  sentimentCounts
+	}{
+		{"title counts twice", "Acme beats", "Acme shares slumped.", sentimentCounts{positive: 2, negative: 1}},
+		{"body repeating the title counts once", "Acme beats", "Acme beats. Acme shares slumped.", sentimentCounts{positive: 3, negative: 1}},
+		{"only sentences about the ticker", "Markets today", "Acme rallied. Rivals crashed.", sentimentCounts{positive: 1}},
+		{"whole article when none mention it", "Markets today", "Stocks rallied. Bonds crashed.", sentimentCounts{positive: 1, negative: 1}},
+	}
+	for _, test := range tests {
+		if got := tickerSentiment(test.title, test.text, ticker); got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// a symbol that's also a word only counts where it's written as a symbol
+func TestTickerSentimentCommonWordSymbol(t *testing.T) {
+	ticker := entityTicker{TickerId: 2, TickerSymbol: "ON", CompanyName: "Onsemi"}
+	tests := []struct {
+		name  string
+		title string
+		text  string
+		want  sentimentCounts
+	}{
+		{"cashtag", "Markets today", "Chipmakers rallied on the news. $ON slumped.", sentimentCounts{negative: 1}},
+		{"exchange prefix", "Markets today", "Chipmakers rallied on the news. Shares of (NASDAQ: ON) slumped.", sentimentCounts{negative: 1}},
+		{"not bare", "Markets today", "Chipmakers rallied on the news. ON slumped.", sentimentCounts{positive: 1, negative: 1}},
+		{"company name", "Markets today", "Chipmakers rallied on the news. Onsemi slumped.", sentimentCounts{negative: 1}},
+	}
+	for _, test := range tests {
+		if got := tickerSentiment(test.title, test.text, ticker); got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statistic value kinds, stored in ticker_attribute.attribute_type
+const (
+	statisticMissing    = "missing"
+	statisticNumber     = "number"
+	statisticPercentage = "percentage"
+	statisticCurrency   = "currency"
+	statisticDate       = "date"
+	statisticText       = "text"
+)
+
+var (
+	statisticMissingValues = map[string]bool{
+		"": true, "-": true, "--": true, "---": true, "n/a": true, "na": true, "nm": true, "none": true,
+	}
+	statisticSuffixes = map[string]float64{
+		"K": 1e3,
+		"M": 1e6,
+		"B": 1e9,
+		"T": 1e12,
+	}
+	statisticCurrencySymbols = []string{"$", "€", "£", "¥"}
+	statisticDateFormats     = []string{"1/2/2006", "01/02/2006", "2006-01-02", "Jan 2, 2006", "January 2, 2006", "02 Jan 2006"}
+
+	statisticCurrencyCode = regexp.MustCompile(`^[A-Z]{3}\s+`)
+	statisticNumeric      = regexp.MustCompile(`^[-+]?(\d+(\.\d*)?|\.\d+)$`)
+)
+
+// StatisticValue is a Bloomberg key statistic broken down into its kind
+// and a typed value, alongside the raw string it came from.
+type StatisticValue struct {
+	Raw    string
+	Kind   string
+	Number sql.NullFloat64
+	Date   sql.NullTime
+}
+
+// parseStatisticValue classifies a raw statistic such as "1.23T", "24.5%",
+// "--" or "5/12/2024" and normalizes it into a number or a date. Percentages
+// are kept in percent units ("24.5%" => 24.5) and K/M/B/T suffixes are
+// expanded ("1.23T" => 1230000000000).
+func parseStatisticValue(raw string) StatisticValue {
+	sv := StatisticValue{Raw: raw, Kind: statisticText}
+
+	value := strings.TrimSpace(raw)
+	if statisticMissingValues[strings.ToLower(value)] {
+		sv.Kind = statisticMissing
+		return sv
+	}
+
+	for _, format := range statisticDateFormats {
+		if date, err := time.Parse(format, value); err == nil {
+			sv.Kind = statisticDate
+			sv.Date = sql.NullTime{Valid: true, Time: date}
+			return sv
+		}
+	}
+
+	kind := statisticNumber
+
+	// accounting style negatives: (1.23)
+	negative := false
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		negative = true
+		value = strings.TrimSpace(value[1 : len(value)-1])
+	}
+	if strings.HasPrefix(value, "-") {
+		negative = !negative
+		value = strings.TrimSpace(value[1:])
+	}
+
+	if code := statisticCurrencyCode.FindString(value); code != "" {
+		kind = statisticCurrency
+		value = strings.TrimSpace(value[len(code):])
+	}
+	for _, symbol := range statisticCurrencySymbols {
+		if strings.HasPrefix(value, symbol) {
+			kind = statisticCurrency
+			value = strings.TrimSpace(strings.TrimPrefix(value, symbol))
+			break
+		}
+	}
+
+	multiplier := 1.0
+	if strings.HasSuffix(value, "%") {
+		kind = statisticPercentage
+		value = strings.TrimSpace(strings.TrimSuffix(value, "%"))
+	} else if len(value) > 0 {
+		if m, ok := statisticSuffixes[strings.ToUpper(value[len(value)-1:])]; ok {
+			multiplier = m
+			value = strings.TrimSpace(value[:len(value)-1])
+		}
+	}
+
+	value = strings.ReplaceAll(value, ",", "")
+	if !statisticNumeric.MatchString(value) {
+		return sv
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return sv
+	}
+	if negative {
+		number = -number
+	}
+
+	sv.Kind = kind
+	sv.Number = sql.NullFloat64{Valid: true, Float64: number * multiplier}
+	return sv
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseStatisticValue(t *testing.T) {
+	number := func(f float64) sql.NullFloat64 { return sql.NullFloat64{Valid: true, Float64: f} }
+	tests := []struct {
+		raw    string
+		kind   string
+		number sql.NullFloat64
+		date   string
+	}{
+		{"12.5", statisticNumber, number(12.5), ""},
+		{"1,234,567", statisticNumber, number(1234567), ""},
+		{"1.23T", statisticNumber, number(1.23e12), ""},
+		{"45.6B", statisticNumber, number(45.6e9), ""},
+		{"789m", statisticNumber, number(789e6), ""},
+		{"12K", statisticNumber, number(12e3), ""},
+		{"24.5%", statisticPercentage, number(24.5), ""},
+		{"-3.2%", statisticPercentage, number(-3.2), ""},
+		{"(1.50)", statisticNumber, number(-1.5), ""},
+		{"-0.75", statisticNumber, number(-0.75), ""},
+		{"$2.5B", statisticCurrency, number(2.5e9), ""},
+		{"USD 1.1M", statisticCurrency, number(1.1e6), ""},
+		{"(€3.00)", statisticCurrency, number(-3), ""},
+		{"N/A", statisticMissing, sql.NullFloat64{}, ""},
+		{" -- ", statisticMissing, sql.NullFloat64{}, ""},
+		{"", statisticMissing, sql.NullFloat64{}, ""},
+		{"5/12/2024", statisticDate, sql.NullFloat64{}, "2024-05-12"},
+		{"Jan 2, 2024", statisticDate, sql.NullFloat64{}, "2024-01-02"},
+		{"Buy", statisticText, sql.NullFloat64{}, ""},
+		{"1.2.3", statisticText, sql.NullFloat64{}, ""},
+		{"12X", statisticText, sql.NullFloat64{}, ""},
+	}
+	for _, test := range tests {
+		got := parseStatisticValue(test.raw)
+		if got.Raw != test.raw {
+			t.Errorf("%q: raw not kept, got %q", test.raw, got.Raw)
+		}
+		if got.Kind != test.kind {
+			t.Errorf("%q: got kind %s, want %s", test.raw, got.Kind, test.kind)
+		}
+		if got.Number.Valid != test.number.Valid || math.Abs(got.Number.Float64-test.number.Float64) > 1e-6*math.Abs(test.number.Float64) {
+			t.Errorf("%q: got number %v, want %v", test.raw, got.Number, test.number)
+		}
+		wantDate, _ := time.Parse("2006-01-02", test.date)
+		if got.Date.Valid != (test.date != "") || (got.Date.Valid && !got.Date.Time.Equal(wantDate)) {
+			t.Errorf("%q: got date %v, want %s", test.raw, got.Date, test.date)
+		}
+	}
+}
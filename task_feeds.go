@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TaskFeedsBody names the feeds to read; with none, every active feed
+type TaskFeedsBody struct {
+	FeedIds []uint64 `json:"feed_ids"`
+}
+
+func perform_feeds(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
+	var taskFeedsBody TaskFeedsBody
+	if body != nil && *body != "" {
+		json.NewDecoder(strings.NewReader(*body)).Decode(&taskFeedsBody)
+	}
+
+	var feeds []Feed
+	var err error
+	if len(taskFeedsBody.FeedIds) > 0 {
+		feeds, err = getFeedsByIds(deps, taskFeedsBody.FeedIds)
+	} else {
+		feeds, err = getActiveFeeds(deps)
+	}
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to load feeds")
+		return false, nil
+	}
+
+	failed := 0
+	for _, feed := range feeds {
+		feedlog := sublog.With().Str("feed_url", feed.FeedURL).Logger()
+		if err := perform_feed(deps, feedlog, feed, false); err != nil {
+			feedlog.Warn().Err(err).Msg("failed to read feed {feed_url}")
+			failed++
+		}
+	}
+	sublog.Info().Int("feeds", len(feeds)).Int("failed", failed).Msg("{action} done for {feeds} feeds, {failed} failed")
+
+	// feeds are read again on the next schedule anyway, so failures aren't
+	// worth retrying the whole task for
+	return true, nil
+}
+
+// perform_feed reads one feed, unless it was read recently (and force
+// isn't set), and stores its new entries as articles
+func perform_feed(deps *Dependencies, sublog zerolog.Logger, feed Feed, force bool) error {
+	db := deps.db
+
+	lastdone := LastDone{Activity: "feed", UniqueKey: feed.FeedURL, LastStatus: "failed"}
+	lastdone.getByActivity(db)
+	if !force && lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.Add(minFeedDelay*time.Minute).After(time.Now()) {
+		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping feed {feed_url}, recently read")
+		return nil
+	}
+
+	loadErr := loadFeedArticles(deps, sublog, feed)
+	if loadErr == nil {
+		lastdone.LastStatus = "success"
+		feed.updateLastFetched(deps)
+	} else {
+		lastdone.LastStatus = fmt.Sprintf("%e", loadErr)
+	}
+
+	lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
+
+	err := lastdone.createOrUpdate(db)
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to create or update lastdone for feed {feed_url}")
+	}
+
+	return loadErr
+}
+
+// loadFeedArticles stores every entry we haven't seen (by its GUID), or
+// refreshes it if it was updated since, and links it to the feed's ticker,
+// if it has one, and any others it mentions
+func loadFeedArticles(deps *Dependencies, sublog zerolog.Logger, feed Feed) error {
+	sourceString := feed.SourceString
+	if sourceString == "" {
+		if parsed, err := url.Parse(feed.FeedURL); err == nil && parsed.Hostname() != "" {
+			sourceString = strings.TrimPrefix(parsed.Hostname(), "www.")
+		} else {
+			sourceString = feed.FeedURL
+		}
+	}
+	source, err := getOrCreateSource(deps, sourceString)
+	if err != nil {
+		return err
+	}
+	if source.Status == sourceBlocked {
+		sublog.Info().Str("source", sourceString).Msg("source {source} is blocked, skipping feed {feed_url}")
+		return nil
+	}
+	sourceId := source.SourceId
+
+	var ticker Ticker
+	if feed.TickerId != 0 {
+		ticker.TickerId = feed.TickerId
+		if err := ticker.getById(deps); err != nil {
+			sublog.Warn().Err(err).Uint64("ticker_id", feed.TickerId).Msg("feed's ticker not found, linking by mentions only")
+			ticker = Ticker{}
+		}
+	}
+
+	start := time.Now()
+	entries, err := fetchFeed(feed.FeedURL)
+	sublog.Info().Int64("response_time", time.Since(start).Nanoseconds()).Msg("timer: feed {feed_url}")
+	if err != nil {
+		return err
+	}
+
+	added, refreshed := 0, 0
+	for _, entry := range entries {
+		if entry.GUID == "" {
+			continue
+		}
+
+		article := Article{
+			SourceId:   sourceId,
+			ExternalId: entry.GUID,
+			Title:      entry.Title,
+			Body:       sanitizeHTML(entry.Body),
+			ArticleURL: entry.Link,
+		}
+		if !entry.Published.IsZero() {
+			article.PublishedDatetime = sql.NullTime{Valid: true, Time: entry.Published}
+			article.PubUpdatedDatetime = article.PublishedDatetime
+		}
+		if !entry.Updated.IsZero() {
+			article.PubUpdatedDatetime = sql.NullTime{Valid: true, Time: entry.Updated}
+		}
+
+		if existingId, aliased, err := getArticleBySourceExternalId(deps, sourceId, entry.GUID); err != nil {
+			sublog.Info().Err(err).Str("existing_id", entry.GUID).Msg("failed to check for existing article by external id")
+			continue
+		} else if aliased {
+			// already saved as a duplicate of another source's story,
+			// which isn't ours to refresh
+			continue
+		} else if existingId != 0 {
+			existing := Article{ArticleId: existingId}
+			if err := existing.getArticleById(deps); err != nil {
+				sublog.Warn().Err(err).Str("external_id", entry.GUID).Msg("failed to load existing feed entry")
+				continue
+			}
+			ok, err := existing.refreshArticle(deps, article)
+			if err != nil {
+				sublog.Warn().Err(err).Str("external_id", entry.GUID).Msg("failed to refresh updated feed entry")
+			}
+			if ok {
+				err = linkArticleTickers(deps, sublog, existing, ticker)
+				if err != nil {
+					sublog.Warn().Err(err).Str("external_id", entry.GUID).Msg("failed to write ticker(s) for updated feed entry")
+				}
+				refreshed++
+			}
+			continue
+		}
+
+		_, err = article.saveArticle(deps)
+		if err != nil {
+			sublog.Warn().Err(err).Str("external_id", entry.GUID).Msg("failed to write new feed entry")
+			continue
+		}
+		err = linkArticleTickers(deps, sublog, article, ticker)
+		if err != nil {
+			sublog.Warn().Err(err).Str("external_id", entry.GUID).Msg("failed to write ticker(s) for new feed entry")
+		}
+		added++
+	}
+	sublog.Info().Int("entries", len(entries)).Int("added", added).Int("refreshed", refreshed).Msg("read {entries} entries from feed {feed_url}, {added} new, {refreshed} updated")
+	return nil
+}
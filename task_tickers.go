@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// TaskTicker is the single ticker a task is for
+type TaskTicker struct {
+	TickerId     uint64 `json:"ticker_id"`
+	TickerSymbol string `json:"ticker_symbol"`
+	ExchangeId   uint64 `json:"exchange_id"`
+}
+
+// TaskTickers lets one task cover a list of tickers, or every ticker in a
+// named watchlist, instead of a single TaskTicker. Attempt counts how many
+// times the failed part of the list has been put back on the queue.
+type TaskTickers struct {
+	Tickers   []TaskTicker `json:"tickers"`
+	Watchlist string       `json:"watchlist"`
+	Attempt   int          `json:"attempt"`
+}
+
+func (tt TaskTickers) isList() bool {
+	return len(tt.Tickers) > 0 || tt.Watchlist != ""
+}
+
+// resolveTaskTickers looks up the tickers a task is for. A single ticker
+// that can't be found is an error, as it always was; in a list, unknown
+// tickers are just logged and skipped.
+func resolveTaskTickers(deps *Dependencies, sublog zerolog.Logger, single TaskTicker, list TaskTickers) ([]Ticker, error) {
+	if !list.isList() {
+		if single.TickerId == 0 && single.TickerSymbol == "" {
+			sublog.Error().Msg("tickerId OR tickerSymbol must be provided")
+			return nil, fmt.Errorf("tickerId OR tickerSymbol must be provided")
+		}
+		ticker, err := getTaskTicker(deps, single)
+		if err != nil {
+			sublog.Error().Interface("ticker", ticker).Msg("couldn't find ticker")
+			return nil, err
+		}
+		return []Ticker{ticker}, nil
+	}
+
+	tickers := make([]Ticker, 0, len(list.Tickers))
+	for _, taskTicker := range list.Tickers {
+		ticker, err := getTaskTicker(deps, taskTicker)
+		if err != nil {
+			sublog.Warn().Err(err).Interface("ticker", taskTicker).Msg("couldn't find ticker, skipping it")
+			continue
+		}
+		tickers = append(tickers, ticker)
+	}
+	if list.Watchlist != "" {
+		watchlistTickers, err := getTickersByWatchlist(deps, list.Watchlist)
+		if err != nil {
+			sublog.Error().Err(err).Str("watchlist", list.Watchlist).Msg("couldn't load watchlist {watchlist}")
+			return nil, err
+		}
+		tickers = append(tickers, watchlistTickers...)
+	}
+	tickers = uniqueTickers(tickers)
+	if len(tickers) == 0 {
+		sublog.Error().Msg("none of the tickers were found")
+		return nil, fmt.Errorf("no tickers found")
+	}
+	return tickers, nil
+}
+
+func getTaskTicker(deps *Dependencies, taskTicker TaskTicker) (Ticker, error) {
+	ticker := Ticker{TickerId: taskTicker.TickerId, TickerSymbol: taskTicker.TickerSymbol}
+	var err error
+	if ticker.TickerId > 0 {
+		err = ticker.getById(deps)
+	} else {
+		err = ticker.getBySymbol(deps)
+	}
+	return ticker, err
+}
+
+// performForTickers runs perform for every ticker, logging how each one
+// went. For a list, the tickers that failed are put back on the queue as a
+// new task (built by requeue from the original body) so only they get
+// retried, up to maxTaskTickerAttempts times. A list longer than
+// schedulerTickersPerTask (a whole watchlist, say) is split into tasks of
+// that size instead, so no one task outlasts its visibility timeout.
+func performForTickers(deps *Dependencies, sublog zerolog.Logger, action string, tickers []Ticker, list TaskTickers, perform func(zerolog.Logger, Ticker) error, requeue func(TaskTickers) interface{}) (bool, error) {
+	if !list.isList() {
+		tickerlog := sublog.With().Str("symbol", tickers[0].TickerSymbol).Logger()
+		return true, perform(tickerlog, tickers[0])
+	}
+	if len(tickers) > schedulerTickersPerTask {
+		return splitTickersTask(deps, sublog, action, tickers, list, requeue)
+	}
+
+	failed := make([]TaskTicker, 0)
+	for _, ticker := range tickers {
+		tickerlog := sublog.With().Str("symbol", ticker.TickerSymbol).Logger()
+		err := perform(tickerlog, ticker)
+		if err != nil {
+			tickerlog.Warn().Err(err).Msg("{action} failed for {symbol}")
+			failed = append(failed, TaskTicker{ticker.TickerId, ticker.TickerSymbol, ticker.ExchangeId})
+			continue
+		}
+		tickerlog.Info().Msg("{action} succeeded for {symbol}")
+	}
+	sublog.Info().Int("tickers", len(tickers)).Int("failed", len(failed)).Msg("{action} done for {tickers} tickers, {failed} failed")
+
+	if len(failed) == 0 {
+		return true, nil
+	}
+	if list.Attempt+1 >= maxTaskTickerAttempts {
+		sublog.Error().Int("failed", len(failed)).Int("attempt", list.Attempt).Msg("giving up on {failed} tickers after {attempt} retries")
+		return true, nil
+	}
+
+	retry := TaskTickers{Tickers: failed, Attempt: list.Attempt + 1}
+	err := enqueueTask(deps, tickersQueueName, action, requeue(retry))
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to requeue failed tickers for {action}")
+	}
+	return true, nil
+}
+
+// splitTickersTask puts a long list back on the queue as tasks of
+// schedulerTickersPerTask tickers each. If any can't be enqueued the whole
+// task is left to be retried; tickers in the tasks that did go out are
+// skipped by their handlers if they were done recently.
+func splitTickersTask(deps *Dependencies, sublog zerolog.Logger, action string, tickers []Ticker, list TaskTickers, requeue func(TaskTickers) interface{}) (bool, error) {
+	for start := 0; start < len(tickers); start += schedulerTickersPerTask {
+		end := start + schedulerTickersPerTask
+		if end > len(tickers) {
+			end = len(tickers)
+		}
+		batch := make([]TaskTicker, 0, end-start)
+		for _, ticker := range tickers[start:end] {
+			batch = append(batch, TaskTicker{ticker.TickerId, ticker.TickerSymbol, ticker.ExchangeId})
+		}
+		err := enqueueTask(deps, tickersQueueName, action, requeue(TaskTickers{Tickers: batch, Attempt: list.Attempt}))
+		if err != nil {
+			sublog.Error().Err(err).Int("tickers", len(tickers)).Msg("failed to split {action} for {tickers} tickers into smaller tasks")
+			return false, nil
+		}
+	}
+	sublog.Info().Int("tickers", len(tickers)).Msg("split {action} for {tickers} tickers into smaller tasks")
+	return true, nil
+}
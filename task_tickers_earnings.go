@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/weirdtangent/yhfinance"
+)
+
+// yhfinance wraps most numbers as {"raw": 1.23, "fmt": "1.23"}
+type YHRawValue struct {
+	Raw *float64 `json:"raw"`
+}
+
+func (v YHRawValue) nullFloat() sql.NullFloat64 {
+	if v.Raw == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Valid: true, Float64: *v.Raw}
+}
+
+// the parts of yhfinance's stockEarnings response we use: reported quarters
+// (keyed by fiscal quarter end), when each was reported, and the next report
+// date and estimates
+type YHEarningsResponse struct {
+	EarningsHistory struct {
+		History []struct {
+			Quarter     YHRawValue `json:"quarter"`
+			EpsActual   YHRawValue `json:"epsActual"`
+			EpsEstimate YHRawValue `json:"epsEstimate"`
+		} `json:"history"`
+	} `json:"earningsHistory"`
+	Earnings struct {
+		EarningsChart struct {
+			// the same recent quarters as the history, oldest first,
+			// labeled by fiscal period ("3Q2024")
+			Quarterly []struct {
+				Date         string     `json:"date"`
+				ReportedDate YHRawValue `json:"reportedDate"`
+			} `json:"quarterly"`
+		} `json:"earningsChart"`
+	} `json:"earnings"`
+	CalendarEvents struct {
+		Earnings struct {
+			EarningsDate    []YHRawValue `json:"earningsDate"`
+			EarningsAverage YHRawValue   `json:"earningsAverage"`
+			RevenueAverage  YHRawValue   `json:"revenueAverage"`
+		} `json:"earnings"`
+	} `json:"calendarEvents"`
+}
+
+type TaskTickerEarningsBody struct {
+	TaskTicker
+	TaskTickers
+}
+
+func perform_tickers_earnings(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
+	if body == nil || *body == "" {
+		sublog.Error().Msg("missing task body for {action}")
+		return true, fmt.Errorf("missing task body")
+	}
+	var taskTickerEarningsBody TaskTickerEarningsBody
+	json.NewDecoder(strings.NewReader(*body)).Decode(&taskTickerEarningsBody)
+
+	tickers, err := resolveTaskTickers(deps, sublog, taskTickerEarningsBody.TaskTicker, taskTickerEarningsBody.TaskTickers)
+	if err != nil {
+		return true, err
+	}
+
+	return performForTickers(deps, sublog, "earnings", tickers, taskTickerEarningsBody.TaskTickers,
+		func(sublog zerolog.Logger, ticker Ticker) error {
+			return perform_ticker_earnings(deps, sublog, ticker)
+		},
+		func(retry TaskTickers) interface{} {
+			return TaskTickerEarningsBody{TaskTickers: retry}
+		})
+}
+
+func perform_ticker_earnings(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
+	db := deps.db
+
+	sublog.Info().Msg("got task to possibly update earnings for {symbol}")
+
+	// skip calling API if we've succeeded at this recently
+	lastdone := LastDone{Activity: "ticker_earnings", UniqueKey: ticker.TickerSymbol, LastStatus: "failed"}
+	lastdone.getByActivity(db)
+	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.Add(minTickerEarningsDelay*time.Minute).After(time.Now()) {
+		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping {action} for {symbol}, recently received")
+		return nil
+	}
+
+	sublog.Info().Msg("pulling earnings for {symbol} from yhfinance")
+	loadErr := loadTickerEarningsFromYH(deps, sublog, ticker)
+	if loadErr == nil {
+		lastdone.LastStatus = "success"
+	} else {
+		lastdone.LastStatus = fmt.Sprintf("%e", loadErr)
+	}
+
+	lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
+
+	err := lastdone.createOrUpdate(db)
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to create or update lastdone for {symbol}")
+	}
+
+	return loadErr
+}
+
+// load reported quarters and the upcoming report
+func loadTickerEarningsFromYH(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
+	apiKey, apiHost := getYHSecrets(deps)
+
+	start := time.Now()
+	response, err := yhfinance.GetFromYHFinance(&sublog, apiKey, apiHost, "stockEarnings", map[string]string{"symbol": ticker.TickerSymbol})
+	sublog.Info().Int64("response_time", time.Since(start).Nanoseconds()).Msg("timer: yhfinance stockEarnings")
+	if err != nil {
+		sublog.Warn().Err(err).Msg("failed to retrieve earnings")
+		return err
+	}
+
+	var earningsResponse YHEarningsResponse
+	err = json.NewDecoder(strings.NewReader(response)).Decode(&earningsResponse)
+	if err != nil {
+		return err
+	}
+
+	allEarnings := tickerEarningsFromYH(ticker.TickerId, earningsResponse)
+	if len(allEarnings) == 0 {
+		sublog.Info().Msg("no reported quarters for {symbol}, nothing to store")
+		return nil
+	}
+
+	var lastErr error
+	for _, earnings := range allEarnings {
+		if err := earnings.createOrUpdate(deps); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// tickerEarningsFromYH turns the response into a row per reported quarter,
+// oldest first, then one for the upcoming report if there is one. The
+// history doesn't say when each quarter was reported, but the earnings
+// chart does, for the same quarters in the same order. The upcoming report
+// doesn't say which quarter it's for, so it's taken to be the one after the
+// latest reported quarter; that's the row its actuals land in once it's
+// out.
+func tickerEarningsFromYH(tickerId uint64, response YHEarningsResponse) []TickerEarnings {
+	allEarnings := make([]TickerEarnings, 0, len(response.EarningsHistory.History)+1)
+	for _, history := range response.EarningsHistory.History {
+		if history.Quarter.Raw == nil {
+			continue
+		}
+		allEarnings = append(allEarnings, TickerEarnings{
+			TickerId:      tickerId,
+			FiscalQuarter: time.Unix(int64(*history.Quarter.Raw), 0).UTC(),
+			EpsEstimate:   history.EpsEstimate.nullFloat(),
+			EpsActual:     history.EpsActual.nullFloat(),
+		})
+	}
+	sort.Slice(allEarnings, func(i, j int) bool {
+		return allEarnings[i].FiscalQuarter.Before(allEarnings[j].FiscalQuarter)
+	})
+
+	// only trust the pairing if the chart has exactly the history's quarters
+	quarterly := response.Earnings.EarningsChart.Quarterly
+	if len(quarterly) == len(allEarnings) {
+		for i := range allEarnings {
+			if reported := quarterly[i].ReportedDate; reported.Raw != nil {
+				allEarnings[i].EarningsDate = sql.NullTime{Valid: true, Time: time.Unix(int64(*reported.Raw), 0).UTC()}
+			}
+		}
+	}
+
+	// with nothing reported there's no quarter to place it after
+	upcoming := response.CalendarEvents.Earnings
+	if len(allEarnings) > 0 && len(upcoming.EarningsDate) > 0 && upcoming.EarningsDate[0].Raw != nil {
+		allEarnings = append(allEarnings, TickerEarnings{
+			TickerId:        tickerId,
+			FiscalQuarter:   nextFiscalQuarter(allEarnings[len(allEarnings)-1].FiscalQuarter),
+			EarningsDate:    sql.NullTime{Valid: true, Time: time.Unix(int64(*upcoming.EarningsDate[0].Raw), 0).UTC()},
+			EpsEstimate:     upcoming.EarningsAverage.nullFloat(),
+			RevenueEstimate: upcoming.RevenueAverage.nullFloat(),
+			quarterGuessed:  true,
+		})
+	}
+	return allEarnings
+}
+
+// nextFiscalQuarter is the end of the quarter after the one ending on
+// quarter: three calendar months on for quarters ending on a month-end,
+// thirteen weeks on for 52/53-week fiscal years that end on a weekday
+// (it's occasionally fourteen, which getByQuarter's slack covers)
+func nextFiscalQuarter(quarter time.Time) time.Time {
+	if quarter.AddDate(0, 0, 1).Day() != 1 {
+		return quarter.AddDate(0, 0, 7*13)
+	}
+	return time.Date(quarter.Year(), quarter.Month()+4, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextFiscalQuarter(t *testing.T) {
+	tests := []struct {
+		quarter string
+		want    string
+	}{
+		{"2024-03-31", "2024-06-30"},
+		{"2024-06-30", "2024-09-30"},
+		{"2024-09-30", "2024-12-31"},
+		{"2024-12-31", "2025-03-31"},
+		{"2023-11-30", "2024-02-29"},
+		// 52/53-week fiscal years
+		{"2024-06-29", "2024-09-28"},
+		{"2024-09-28", "2024-12-28"},
+		{"2023-12-30", "2024-03-30"},
+	}
+	for _, test := range tests {
+		quarter, _ := time.Parse("2006-01-02", test.quarter)
+		if got := nextFiscalQuarter(quarter).Format("2006-01-02"); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.quarter, got, test.want)
+		}
+	}
+}
+
+func TestTickerEarningsFromYH(t *testing.T) {
+	unix := func(date string) int64 {
+		parsed, _ := time.Parse("2006-01-02", date)
+		return parsed.Unix()
+	}
+	response := `{
+		"earningsHistory": {"history": [
+			{"quarter": {"raw": ` + itoa(unix("2024-06-29")) + `}, "epsActual": {"raw": 1.5}, "epsEstimate": {"raw": 1.2}},
+			{"quarter": {"raw": ` + itoa(unix("2024-03-30")) + `}, "epsActual": {"raw": 1.1}, "epsEstimate": {}}
+		]},
+		"earnings": {"earningsChart": {"quarterly": [
+			{"date": "2Q2024", "reportedDate": {"raw": ` + itoa(unix("2024-05-02")) + `}},
+			{"date": "3Q2024", "reportedDate": {"raw": ` + itoa(unix("2024-08-01")) + `}}
+		]}},
+		"calendarEvents": {"earnings": {
+			"earningsDate": [{"raw": ` + itoa(unix("2024-10-31")) + `}],
+			"earningsAverage": {"raw": 1.6},
+			"revenueAverage": {"raw": 95000000000}
+		}}
+	}`
+
+	var earningsResponse YHEarningsResponse
+	if err := json.NewDecoder(strings.NewReader(response)).Decode(&earningsResponse); err != nil {
+		t.Fatal(err)
+	}
+	allEarnings := tickerEarningsFromYH(7, earningsResponse)
+
+	want := []struct {
+		quarter, reported string
+		guessed           bool
+	}{
+		{"2024-03-30", "2024-05-02", false},
+		{"2024-06-29", "2024-08-01", false},
+		{"2024-09-28", "2024-10-31", true},
+	}
+	if len(allEarnings) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(allEarnings), len(want))
+	}
+	for i, earnings := range allEarnings {
+		if earnings.TickerId != 7 || earnings.FiscalQuarter.Format("2006-01-02") != want[i].quarter || earnings.quarterGuessed != want[i].guessed {
+			t.Errorf("row %d: got ticker %d quarter %s guessed %v", i, earnings.TickerId, earnings.FiscalQuarter.Format("2006-01-02"), earnings.quarterGuessed)
+		}
+		if !earnings.EarningsDate.Valid || earnings.EarningsDate.Time.Format("2006-01-02") != want[i].reported {
+			t.Errorf("row %d: got earnings date %v, want %s", i, earnings.EarningsDate, want[i].reported)
+		}
+	}
+	if !allEarnings[1].EpsActual.Valid || allEarnings[1].EpsActual.Float64 != 1.5 || allEarnings[0].EpsEstimate.Valid {
+		t.Errorf("eps not carried over: %+v", allEarnings[:2])
+	}
+	if !allEarnings[2].RevenueEstimate.Valid || allEarnings[2].EpsActual.Valid {
+		t.Errorf("upcoming row wrong: %+v", allEarnings[2])
+	}
+
+	// no reported quarters, nowhere to put the upcoming report
+	earningsResponse.EarningsHistory.History = nil
+	if allEarnings := tickerEarningsFromYH(7, earningsResponse); len(allEarnings) != 0 {
+		t.Errorf("got %d rows with no history", len(allEarnings))
+	}
+}
+
+func itoa(n int64) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+// a report dated for a day counts from that day's close in New York, not
+// from some hour of the UTC date
+func TestFinancialsAvailableSince(t *testing.T) {
+	tests := []struct {
+		reportDate string
+		want       string
+	}{
+		{"2024-07-25", "2024-07-25T22:00:00Z"},
+		// early close the day after Thanksgiving
+		{"2024-11-29", "2024-11-29T20:00:00Z"},
+		// not a trading day, so the regular close
+		{"2024-07-27", "2024-07-27T22:00:00Z"},
+	}
+	for _, test := range tests {
+		reportDate, _ := time.Parse("2006-01-02", test.reportDate)
+		if got := usCalendar.financialsAvailableSince(reportDate).UTC().Format(time.RFC3339); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.reportDate, got, test.want)
+		}
+	}
+}
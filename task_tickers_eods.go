@@ -12,15 +12,40 @@ import (
 	"github.com/weirdtangent/yhfinance"
 )
 
+// yhfinance's historical events include dividends as well as splits, but
+// YHHistoricalDataResponse only carries what a split needs
+type YHHistoricalEvents struct {
+	Events []struct {
+		Date     int64   `json:"date"`
+		Type     string  `json:"type"`
+		Amount   float64 `json:"amount"`
+		Currency string  `json:"currency"`
+	} `json:"eventsData"`
+}
+
+// StartDate/EndDate (2006-01-02) limit the task to that range of days
+// instead of yhfinance's default window; in "backfill" mode days we already
+// have are left alone and only the missing ones are added
 type TaskTickerEODsBody struct {
-	TickerId     uint64 `json:"ticker_id"`
-	TickerSymbol string `json:"ticker_symbol"`
-	ExchangeId   uint64 `json:"exchange_id"`
+	TaskTicker
+	TaskTickers
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Mode      string `json:"mode"`
 }
 
-func perform_tickers_eods(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
-	db := deps.db
+// a date range for an eods task, or nothing for the default window
+type eodsRange struct {
+	start    time.Time
+	end      time.Time
+	backfill bool
+}
 
+func (er *eodsRange) isSet() bool {
+	return !er.start.IsZero()
+}
+
+func perform_tickers_eods(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
 	if body == nil || *body == "" {
 		sublog.Error().Msg("missing task body for {action}")
 		return true, fmt.Errorf("missing task body")
@@ -28,65 +53,102 @@ func perform_tickers_eods(deps *Dependencies, sublog zerolog.Logger, body *strin
 	var taskTickerEODsBody TaskTickerEODsBody
 	json.NewDecoder(strings.NewReader(*body)).Decode(&taskTickerEODsBody)
 
-	if taskTickerEODsBody.TickerId == 0 && taskTickerEODsBody.TickerSymbol == "" {
-		sublog.Error().Msg("tickerId OR tickerSymbol must be provided")
-		return true, fmt.Errorf("tickerId OR tickerSymbol must be provided")
+	dateRange, err := parseEODsRange(taskTickerEODsBody)
+	if err != nil {
+		sublog.Error().Err(err).Msg("invalid date range for {action}")
+		return true, err
 	}
 
-	ticker := Ticker{TickerId: taskTickerEODsBody.TickerId, TickerSymbol: taskTickerEODsBody.TickerSymbol}
-	var err error
-	if ticker.TickerId > 0 {
-		err = ticker.getById(deps)
-	} else {
-		err = ticker.getBySymbol(deps)
-	}
+	tickers, err := resolveTaskTickers(deps, sublog, taskTickerEODsBody.TaskTicker, taskTickerEODsBody.TaskTickers)
 	if err != nil {
-		sublog.Error().Interface("ticker", ticker).Msg("couldn't find ticker")
 		return true, err
 	}
 
-	sublog = sublog.With().Str("symbol", ticker.TickerSymbol).Logger()
-	sublog.Info().Msg("got task to possibly update news for {symbol}")
+	return performForTickers(deps, sublog, "eods", tickers, taskTickerEODsBody.TaskTickers,
+		func(sublog zerolog.Logger, ticker Ticker) error {
+			if dateRange.isSet() {
+				return perform_ticker_eods_range(deps, sublog, ticker, dateRange)
+			}
+			return perform_ticker_eods(deps, sublog, ticker)
+		},
+		func(retry TaskTickers) interface{} {
+			return TaskTickerEODsBody{TaskTickers: retry, StartDate: taskTickerEODsBody.StartDate, EndDate: taskTickerEODsBody.EndDate, Mode: taskTickerEODsBody.Mode}
+		})
+}
+
+func parseEODsRange(taskTickerEODsBody TaskTickerEODsBody) (eodsRange, error) {
+	var dateRange eodsRange
+	if taskTickerEODsBody.Mode != "" && taskTickerEODsBody.Mode != "backfill" {
+		return dateRange, fmt.Errorf("unknown mode '%s'", taskTickerEODsBody.Mode)
+	}
+	dateRange.backfill = taskTickerEODsBody.Mode == "backfill"
+
+	if taskTickerEODsBody.StartDate == "" {
+		if taskTickerEODsBody.EndDate != "" || dateRange.backfill {
+			return dateRange, fmt.Errorf("start_date must be provided")
+		}
+		return dateRange, nil
+	}
+
+	var err error
+	dateRange.start, err = time.Parse("2006-01-02", taskTickerEODsBody.StartDate)
+	if err != nil {
+		return dateRange, err
+	}
+	dateRange.end = time.Now()
+	if taskTickerEODsBody.EndDate != "" {
+		dateRange.end, err = time.Parse("2006-01-02", taskTickerEODsBody.EndDate)
+		if err != nil {
+			return dateRange, err
+		}
+	}
+	if dateRange.end.Before(dateRange.start) {
+		return dateRange, fmt.Errorf("end_date is before start_date")
+	}
+	return dateRange, nil
+}
+
+func perform_ticker_eods(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
+	db := deps.db
+
+	sublog.Info().Msg("got task to possibly update eods for {symbol}")
 
-	// skip calling API if we've succeeded at this recently
+	// skip calling API if we've succeeded at this since the last session
+	// closed, there can't be a new bar yet (weekends, holidays, mid-session)
+	calendar := getTradingCalendar(deps, ticker.ExchangeId)
 	lastdone := LastDone{Activity: "ticker_eods", UniqueKey: ticker.TickerSymbol, LastStatus: "failed"}
 	lastdone.getByActivity(db)
-	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.Add(minTickerEODsDelay*time.Minute).After(time.Now()) {
-		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping {action} for {symbol}, recently received")
-		return true, nil
+	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.After(calendar.newEODAvailableSince(time.Now())) {
+		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping {action} for {symbol}, no new session since last received")
+		return nil
 	}
 
 	// go get daily pricing from yhfinance
 	sublog.Info().Msg("pulling daily pricing {symbol} from yhfinance")
-	err = loadTickerEODsFromYH(deps, ticker)
-	if err == nil {
+	loadErr := loadTickerEODsFromYH(deps, ticker)
+	if loadErr == nil {
 		lastdone.LastStatus = "success"
 	} else {
-		lastdone.LastStatus = fmt.Sprintf("%e", err)
+		lastdone.LastStatus = fmt.Sprintf("%e", loadErr)
 	}
 
 	lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
 
-	err = lastdone.createOrUpdate(db)
+	err := lastdone.createOrUpdate(db)
 	if err != nil {
 		sublog.Error().Err(err).Msg("failed to create or update lastdone for {symbol}")
 	}
 
-	return true, nil
+	return loadErr
 }
 
 // load ticker historical prices
 func loadTickerEODsFromYH(deps *Dependencies, ticker Ticker) error {
-	secrets := deps.secrets
 	sublog := deps.logger
 
 	historicalParams := map[string]string{"symbol": ticker.TickerSymbol}
 
-	apiKey := secrets["yhfinance_rapidapi_key"]
-	apiHost := secrets["yhfinance_rapidapi_host"]
-	if apiKey == "" || apiHost == "" {
-		sublog.Fatal().Msg("apiKey or apiHost secret is missing")
-	}
+	apiKey, apiHost := getYHSecrets(deps)
 
 	start := time.Now()
 	response, err := yhfinance.GetFromYHFinance(sublog, apiKey, apiHost, "stockHistorical", historicalParams)
@@ -98,30 +160,171 @@ func loadTickerEODsFromYH(deps *Dependencies, ticker Ticker) error {
 
 	var historicalResponse yhfinance.YHHistoricalDataResponse
 	json.NewDecoder(strings.NewReader(response)).Decode(&historicalResponse)
+	var historicalEvents YHHistoricalEvents
+	json.NewDecoder(strings.NewReader(response)).Decode(&historicalEvents)
 
-	var lastErr error
+	splits := make([]TickerSplit, 0, len(historicalResponse.Events))
+	for _, split := range historicalResponse.Events {
+		splits = append(splits, TickerSplit{0, "", ticker.TickerId, time.Unix(split.Date, 0), split.SplitRatio, sql.NullFloat64{}, time.Now(), time.Now()})
+	}
+	dividends := make([]TickerDividend, 0, len(historicalEvents.Events))
+	for _, event := range historicalEvents.Events {
+		if event.Type != "DIVIDEND" {
+			continue
+		}
+		currency := event.Currency
+		if currency == "" {
+			currency = "USD"
+		}
+		dividends = append(dividends, TickerDividend{0, "", ticker.TickerId, time.Unix(event.Date, 0), event.Amount, currency, time.Now(), time.Now()})
+	}
+	// splits go in first, bar validation needs to know about them
+	newSplit, newDividend, err := storeTickerEvents(deps, splits, dividends)
+	if err != nil {
+		log.Warn().Err(err).Str("ticker", ticker.TickerSymbol).Msg("failed to load at least one historical split or dividend")
+	}
+
+	tickerDailies := make([]TickerDaily, 0, len(historicalResponse.Prices))
 	for _, price := range historicalResponse.Prices {
 		priceDatetime := time.Unix(price.Date, 0)
-		tickerDaily := TickerDaily{0, "", ticker.TickerId, priceDatetime, price.Open, price.High, price.Low, price.Close, price.Volume, time.Now(), time.Now()}
-		err = tickerDaily.createOrUpdate(deps)
+		tickerDailies = append(tickerDailies, TickerDaily{TickerId: ticker.TickerId, PriceDatetime: priceDatetime, OpenPrice: price.Open, HighPrice: price.High, LowPrice: price.Low, ClosePrice: price.Close, Volume: price.Volume})
+	}
+	tickerlog := sublog.With().Str("symbol", ticker.TickerSymbol).Logger()
+	_, err = storeValidTickerDailies(deps, tickerlog, ticker, tickerDailies)
+	if err != nil {
+		log.Warn().Err(err).Str("ticker", ticker.TickerSymbol).Msg("failed to load at least one historical price")
+	}
+
+	// a new split or dividend changes every adjusted price before it,
+	// otherwise we only need to fill in the bars we just added
+	adjustedFrom, err := adjustTickerDailies(deps, ticker.TickerId, !newSplit && !newDividend)
+	if err != nil {
+		log.Warn().Err(err).Str("ticker", ticker.TickerSymbol).Msg("failed to update split-adjusted prices")
+		return nil
+	}
+
+	// indicators come from the split-adjusted closes, so a new split or a
+	// corrected older bar means starting them over
+	err = updateTickerIndicators(deps, tickerlog, ticker.TickerId, adjustedFrom, newSplit)
+	if err != nil {
+		log.Warn().Err(err).Str("ticker", ticker.TickerSymbol).Msg("failed to update indicators")
+	}
+
+	return nil
+}
+
+// perform_ticker_eods_range loads a specific range of days, which is always
+// done regardless of lastdone since it's asked for explicitly
+func perform_ticker_eods_range(deps *Dependencies, sublog zerolog.Logger, ticker Ticker, dateRange eodsRange) error {
+	sublog = sublog.With().Str("start_date", dateRange.start.Format("2006-01-02")).Str("end_date", dateRange.end.Format("2006-01-02")).Bool("backfill", dateRange.backfill).Logger()
+	sublog.Info().Msg("pulling daily pricing {symbol} from {start_date} to {end_date} from yhfinance")
+
+	var lastErr error
+	added := 0
+	newSplit, newDividend := false, false
+	// walk the range in chunks so a long history doesn't come back as one
+	// enormous response
+	for chunkStart := dateRange.start; !chunkStart.After(dateRange.end); chunkStart = chunkStart.AddDate(0, 0, eodsBackfillChunkDays) {
+		chunkEnd := chunkStart.AddDate(0, 0, eodsBackfillChunkDays-1)
+		if chunkEnd.After(dateRange.end) {
+			chunkEnd = dateRange.end
+		}
+		count, chunkSplit, chunkDividend, err := loadTickerEODRangeFromYH(deps, sublog, ticker, chunkStart, chunkEnd, dateRange.backfill)
 		if err != nil {
+			sublog.Warn().Err(err).Str("chunk_start", chunkStart.Format("2006-01-02")).Msg("failed to load daily pricing starting {chunk_start}")
 			lastErr = err
 		}
+		added += count
+		newSplit = newSplit || chunkSplit
+		newDividend = newDividend || chunkDividend
 	}
-	if lastErr != nil {
-		log.Warn().Err(err).Str("ticker", ticker.TickerSymbol).Msg("failed to load at least one historical price")
+	sublog.Info().Int("added", added).Bool("new_split", newSplit).Bool("new_dividend", newDividend).Msg("loaded {added} daily prices for {symbol}")
+
+	// same as the regular path: a new split or dividend changes every
+	// adjusted price before it
+	adjustedFrom, err := adjustTickerDailies(deps, ticker.TickerId, !newSplit && !newDividend)
+	if err != nil {
+		sublog.Warn().Err(err).Msg("failed to update split-adjusted prices")
+		return lastErr
 	}
 
-	for _, split := range historicalResponse.Events {
-		tickerSplit := TickerSplit{0, "", ticker.TickerId, time.Unix(split.Date, 0), split.SplitRatio, time.Now(), time.Now()}
-		err = tickerSplit.createIfNew(deps)
+	// the range may have filled in days before the newest indicators, in
+	// which case they're redone from the start, as they are after a split
+	if added > 0 || newSplit {
+		err = updateTickerIndicators(deps, sublog, ticker.TickerId, adjustedFrom, newSplit)
+		if err != nil {
+			sublog.Warn().Err(err).Msg("failed to update indicators")
+		}
+	}
+
+	return lastErr
+}
+
+// storeTickerEvents adds the splits and dividends we don't have yet,
+// reporting whether there were any new ones of each; the error is the last
+// one any of them hit
+func storeTickerEvents(deps *Dependencies, splits []TickerSplit, dividends []TickerDividend) (newSplit bool, newDividend bool, lastErr error) {
+	for _, split := range splits {
+		isNew, err := split.createIfNew(deps)
 		if err != nil {
 			lastErr = err
 		}
+		newSplit = newSplit || isNew
 	}
-	if lastErr != nil {
-		log.Warn().Err(err).Str("ticker", ticker.TickerSymbol).Msg("failed to load at least one historical split")
+	for _, dividend := range dividends {
+		isNew, err := dividend.createIfNew(deps)
+		if err != nil {
+			lastErr = err
+		}
+		newDividend = newDividend || isNew
 	}
+	return newSplit, newDividend, lastErr
+}
 
-	return nil
+// load ticker daily prices for the days from start through end, along with
+// any splits and dividends in them; with skipExisting, days already in
+// ticker_daily aren't touched
+func loadTickerEODRangeFromYH(deps *Dependencies, sublog zerolog.Logger, ticker Ticker, start, end time.Time, skipExisting bool) (added int, newSplit bool, newDividend bool, err error) {
+	existing := make(map[string]bool)
+	if skipExisting {
+		existing, err = getTickerDailyDates(deps, ticker.TickerId, start, end)
+		if err != nil {
+			return 0, false, false, err
+		}
+	}
+
+	params := map[string]string{
+		"interval": "1d",
+		"period1":  fmt.Sprintf("%d", start.Unix()),
+		"period2":  fmt.Sprintf("%d", end.AddDate(0, 0, 1).Unix()),
+		"events":   "div,split",
+	}
+	chart, err := getYHChart(deps, ticker.TickerSymbol, params)
+	if err != nil {
+		return 0, false, false, err
+	}
+
+	splits := make([]TickerSplit, 0, len(chart.Splits))
+	for _, split := range chart.Splits {
+		splits = append(splits, TickerSplit{0, "", ticker.TickerId, split.Date, split.SplitRatio, sql.NullFloat64{}, time.Now(), time.Now()})
+	}
+	dividends := make([]TickerDividend, 0, len(chart.Dividends))
+	for _, dividend := range chart.Dividends {
+		dividends = append(dividends, TickerDividend{0, "", ticker.TickerId, dividend.Date, dividend.Amount, dividend.Currency, time.Now(), time.Now()})
+	}
+	// splits go in first, bar validation needs to know about them
+	newSplit, newDividend, err = storeTickerEvents(deps, splits, dividends)
+	if err != nil {
+		sublog.Warn().Err(err).Msg("failed to load at least one split or dividend for {symbol}")
+	}
+
+	tickerDailies := make([]TickerDaily, 0, len(chart.Bars))
+	for _, bar := range chart.Bars {
+		if existing[bar.Datetime.Format("2006-01-02")] {
+			continue
+		}
+		tickerDailies = append(tickerDailies, TickerDaily{TickerId: ticker.TickerId, PriceDatetime: bar.Datetime, OpenPrice: bar.Open, HighPrice: bar.High, LowPrice: bar.Low, ClosePrice: bar.Close, Volume: bar.Volume})
+	}
+	added, err = storeValidTickerDailies(deps, sublog, ticker, tickerDailies)
+	return added, newSplit, newDividend, err
 }
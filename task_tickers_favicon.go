@@ -2,29 +2,24 @@ package main
 
 import (
 	"crypto/sha1"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/rs/zerolog"
 	"golang.org/x/net/html"
 )
 
 type TaskTickerFavIconBody struct {
-	TickerId     uint64 `json:"ticker_id"`
-	TickerSymbol string `json:"ticker_symbol"`
-	ExchangeId   uint64 `json:"exchange_id"`
+	TaskTicker
+	TaskTickers
 }
 
 func perform_tickers_favicon(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
-	db := deps.db
-
 	if body == nil || *body == "" {
 		sublog.Error().Msg("missing task body")
 		return true, fmt.Errorf("missing task body")
@@ -32,24 +27,23 @@ func perform_tickers_favicon(deps *Dependencies, sublog zerolog.Logger, body *st
 	var taskTickerFavIconBody TaskTickerFavIconBody
 	json.NewDecoder(strings.NewReader(*body)).Decode(&taskTickerFavIconBody)
 
-	if taskTickerFavIconBody.TickerId == 0 && taskTickerFavIconBody.TickerSymbol == "" {
-		sublog.Error().Msg("tickerId OR tickerSymbol must be provided")
-		return true, fmt.Errorf("tickerId OR tickerSymbol must be provided")
-	}
-
-	ticker := Ticker{TickerId: taskTickerFavIconBody.TickerId, TickerSymbol: taskTickerFavIconBody.TickerSymbol}
-	var err error
-	if ticker.TickerId > 0 {
-		err = ticker.getById(deps)
-	} else {
-		err = ticker.getBySymbol(deps)
-	}
+	tickers, err := resolveTaskTickers(deps, sublog, taskTickerFavIconBody.TaskTicker, taskTickerFavIconBody.TaskTickers)
 	if err != nil {
-		sublog.Error().Interface("ticker", ticker).Msg("couldn't find ticker")
 		return true, err
 	}
 
-	sublog = sublog.With().Str("symbol", ticker.TickerSymbol).Logger()
+	return performForTickers(deps, sublog, "favicon", tickers, taskTickerFavIconBody.TaskTickers,
+		func(sublog zerolog.Logger, ticker Ticker) error {
+			return perform_ticker_favicon(deps, sublog, ticker)
+		},
+		func(retry TaskTickers) interface{} {
+			return TaskTickerFavIconBody{TaskTickers: retry}
+		})
+}
+
+func perform_ticker_favicon(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
+	db := deps.db
+
 	sublog.Info().Msg("got task to possibly update favicon for {symbol}")
 
 	// skip calling API if we've succeeded at this recently
@@ -57,18 +51,28 @@ func perform_tickers_favicon(deps *Dependencies, sublog zerolog.Logger, body *st
 	lastdone.getByActivity(db)
 	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.Add(minTickerFavIconDelay*time.Minute).After(time.Now()) {
 		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping {action} for {symbol}, recently received")
-		return true, nil
+		return nil
 	}
 
 	// go get favicon
-	err = saveFavIcon(deps, sublog, ticker)
+	err := saveFavIcon(deps, sublog, ticker)
+	if err == nil {
+		lastdone.LastStatus = "success"
+	} else {
+		lastdone.LastStatus = fmt.Sprintf("%e", err)
+	}
+
+	lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
+
+	lderr := lastdone.createOrUpdate(db)
+	if lderr != nil {
+		sublog.Error().Err(lderr).Msg("failed to create or update lastdone for {symbol}")
+	}
 
-	return true, err
+	return err
 }
 
 func saveFavIcon(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
-	awssess := deps.awssess
-
 	iconUrl := ""
 
 	if ticker.Website == "" {
@@ -146,20 +150,9 @@ func saveFavIcon(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error
 	if err != nil {
 		return err
 	}
-	faviconData := string(body)
-
-	s3svc := s3.New(awssess)
-	sha1Hash := sha1.New()
-	io.WriteString(sha1Hash, faviconData)
-	s3Key := fmt.Sprintf("Tickers/FavIcons/%s-%x", ticker.TickerSymbol, sha1Hash.Sum(nil))
-
-	inputPutObj := &s3.PutObjectInput{
-		Body:   aws.ReadSeekCloser(strings.NewReader(faviconData)),
-		Bucket: aws.String(awsPrivateBucketName),
-		Key:    aws.String(s3Key),
-	}
 
-	_, err = s3svc.PutObject(inputPutObj)
+	s3Key := fmt.Sprintf("Tickers/FavIcons/%s-%x", ticker.TickerSymbol, sha1.Sum(body))
+	err = putPrivateObject(deps, s3Key, body, "")
 	if err != nil {
 		return err
 	}
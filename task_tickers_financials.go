@@ -10,31 +10,35 @@ import (
 	"github.com/rs/zerolog"
 )
 
-func perform_tickers_financials(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
-	db := deps.db
+type TaskTickerFinancialsBody struct {
+	TaskTicker
+	TaskTickers
+}
 
+func perform_tickers_financials(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
 	if body == nil || *body == "" {
 		return false, fmt.Errorf("missing task body")
 	}
-	var taskTickerNewsBody TaskTickerNewsBody
-	json.NewDecoder(strings.NewReader(*body)).Decode(&taskTickerNewsBody)
-
-	if taskTickerNewsBody.TickerId == 0 && taskTickerNewsBody.TickerSymbol == "" {
-		return false, fmt.Errorf("tickerId OR tickerSymbol must be provided")
-	}
+	var taskTickerFinancialsBody TaskTickerFinancialsBody
+	json.NewDecoder(strings.NewReader(*body)).Decode(&taskTickerFinancialsBody)
 
-	ticker := Ticker{TickerId: taskTickerNewsBody.TickerId, TickerSymbol: taskTickerNewsBody.TickerSymbol}
-	var err error
-	if ticker.TickerId > 0 {
-		err = ticker.getById(deps)
-	} else {
-		err = ticker.getBySymbol(deps)
-	}
+	tickers, err := resolveTaskTickers(deps, sublog, taskTickerFinancialsBody.TaskTicker, taskTickerFinancialsBody.TaskTickers)
 	if err != nil {
 		return false, err
 	}
 
-	sublog = sublog.With().Str("symbol", ticker.TickerSymbol).Logger()
+	return performForTickers(deps, sublog, "financials", tickers, taskTickerFinancialsBody.TaskTickers,
+		func(sublog zerolog.Logger, ticker Ticker) error {
+			return perform_ticker_financials(deps, sublog, ticker)
+		},
+		func(retry TaskTickers) interface{} {
+			return TaskTickerFinancialsBody{TaskTickers: retry}
+		})
+}
+
+func perform_ticker_financials(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
+	db := deps.db
+
 	sublog.Info().Msg("got task to possibly update financials for {symbol}")
 
 	lastdone := LastDone{Activity: "ticker_financials", UniqueKey: ticker.TickerSymbol, LastStatus: "failed"}
@@ -42,25 +46,25 @@ func perform_tickers_financials(deps *Dependencies, sublog zerolog.Logger, body
 
 	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.Add(minTickerFinancialsDelay*time.Minute).After(time.Now()) {
 		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping {action} for {symbol}, recently received")
-		return true, nil
+		return nil
 	}
 
 	// go get financials
 	sublog.Info().Msg("pulling financials for {symbol}")
-	err = loadBBFinancials(deps, ticker)
+	err := loadBBFinancials(deps, ticker)
 	if err != nil {
 		lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
 		lderr := lastdone.createOrUpdate(db)
 		if lderr != nil {
 			sublog.Error().Err(lderr).Msg("failed to create or update lastdone for {symbol}")
 		}
-		return true, err
+		return err
 	}
 
 	// go get statistics
 	sublog.Info().Msg("pulling statistics for {symbol}")
-	err = loadBBStatistics(deps, ticker)
-	if err == nil {
+	loadErr := loadBBStatistics(deps, ticker)
+	if loadErr == nil {
 		lastdone.LastStatus = "success"
 	}
 	lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
@@ -70,5 +74,5 @@ func perform_tickers_financials(deps *Dependencies, sublog zerolog.Logger, body
 		sublog.Error().Err(err).Msg("failed to create or update lastdone for {symbol}")
 	}
 
-	return true, nil
+	return loadErr
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type TaskTickerGapsBody struct {
+	TaskTicker
+	TaskTickers
+}
+
+func perform_tickers_gaps(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
+	if body == nil || *body == "" {
+		sublog.Error().Msg("missing task body for {action}")
+		return true, fmt.Errorf("missing task body")
+	}
+	var taskTickerGapsBody TaskTickerGapsBody
+	json.NewDecoder(strings.NewReader(*body)).Decode(&taskTickerGapsBody)
+
+	tickers, err := resolveTaskTickers(deps, sublog, taskTickerGapsBody.TaskTicker, taskTickerGapsBody.TaskTickers)
+	if err != nil {
+		return true, err
+	}
+
+	return performForTickers(deps, sublog, "gaps", tickers, taskTickerGapsBody.TaskTickers,
+		func(sublog zerolog.Logger, ticker Ticker) error {
+			return perform_ticker_gaps(deps, sublog, ticker)
+		},
+		func(retry TaskTickers) interface{} {
+			return TaskTickerGapsBody{TaskTickers: retry}
+		})
+}
+
+func perform_ticker_gaps(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
+	db := deps.db
+
+	sublog.Info().Msg("got task to possibly check ticker_daily gaps for {symbol}")
+
+	// skip if we've checked recently
+	lastdone := LastDone{Activity: "ticker_gaps", UniqueKey: ticker.TickerSymbol, LastStatus: "failed"}
+	lastdone.getByActivity(db)
+	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.Add(minTickerGapsDelay*time.Minute).After(time.Now()) {
+		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping {action} for {symbol}, recently checked")
+		return nil
+	}
+
+	checkErr := repairTickerDailyGaps(deps, sublog, ticker)
+	if checkErr == nil {
+		lastdone.LastStatus = "success"
+	} else {
+		lastdone.LastStatus = fmt.Sprintf("%e", checkErr)
+	}
+
+	lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
+
+	err := lastdone.createOrUpdate(db)
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to create or update lastdone for {symbol}")
+	}
+
+	return checkErr
+}
+
+// repairTickerDailyGaps compares ticker_daily against the exchange's trading
+// calendar, records every run of missing trading days, marks gaps that have
+// since been filled as repaired, and enqueues backfill tasks for the rest;
+// gaps still missing after maxGapBackfillAttempts are marked unfillable
+func repairTickerDailyGaps(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
+	calendar := getTradingCalendar(deps, ticker.ExchangeId)
+	if !calendar.knowsHolidays() {
+		// every holiday would look like a gap
+		sublog.Info().Str("calendar", calendar.Name).Msg("no holidays known for {symbol}'s exchange, not checking gaps")
+		return nil
+	}
+
+	firstDate, err := getTickerDailyFirstDate(deps, ticker.TickerId)
+	if err != nil {
+		return err
+	}
+	if firstDate.IsZero() {
+		sublog.Info().Msg("no daily prices for {symbol} yet, nothing to check")
+		return nil
+	}
+
+	start := firstDate
+	if lookback := time.Now().AddDate(0, 0, -gapsLookbackDays); start.Before(lookback) {
+		start = lookback
+	}
+	// the most recent session we should already have a bar for
+	end := calendar.newEODAvailableSince(time.Now()).Add(-eodsPostCloseDelay * time.Minute)
+
+	existing, err := getTickerDailyDates(deps, ticker.TickerId, start, end)
+	if err != nil {
+		return err
+	}
+	// days the provider did give us a bar for, just not one we'd store
+	// (flagged, or no volume), aren't missing: fetching them again only gets
+	// the same bar
+	flagged, err := getTickerDailyQualityDates(deps, ticker.TickerId, start, end)
+	if err != nil {
+		return err
+	}
+	for date := range flagged {
+		existing[date] = true
+	}
+	gaps := findTickerDailyGaps(calendar, ticker.TickerId, existing, start, end)
+
+	known, err := getOpenTickerDailyGaps(deps, ticker.TickerId)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool, len(gaps))
+	for _, gap := range gaps {
+		current[gap.GapStart.Format("2006-01-02")] = true
+	}
+	for _, gap := range known {
+		if !current[gap.GapStart.Format("2006-01-02")] {
+			gap.Status = "repaired"
+			if err := gap.updateStatus(deps); err != nil {
+				sublog.Warn().Err(err).Msg("failed to mark gap repaired")
+			}
+		}
+	}
+
+	queued := 0
+	for _, gap := range gaps {
+		err := gap.createOrUpdate(deps)
+		if err != nil {
+			sublog.Warn().Err(err).Msg("failed to record gap")
+			continue
+		}
+		if gap.Attempts >= maxGapBackfillAttempts {
+			if gap.Status != "unfillable" {
+				gap.Status = "unfillable"
+				if err := gap.updateStatus(deps); err != nil {
+					sublog.Warn().Err(err).Msg("failed to mark gap unfillable")
+				}
+			}
+			continue
+		}
+		if queued >= maxGapBackfillsPerTicker {
+			continue
+		}
+		if gap.QueuedDatetime.Valid && gap.QueuedDatetime.Time.Add(minTickerGapsDelay*time.Minute).After(time.Now()) {
+			// backfill already on its way
+			continue
+		}
+
+		body := TaskTickerEODsBody{
+			TaskTicker: TaskTicker{TickerId: ticker.TickerId, TickerSymbol: ticker.TickerSymbol, ExchangeId: ticker.ExchangeId},
+			StartDate:  gap.GapStart.Format("2006-01-02"),
+			EndDate:    gap.GapEnd.Format("2006-01-02"),
+			Mode:       "backfill",
+		}
+		err = enqueueTask(deps, tickersQueueName, "eods", body)
+		if err != nil {
+			sublog.Error().Err(err).Msg("failed to enqueue backfill for gap")
+			continue
+		}
+		gap.Status = "queued"
+		gap.Attempts++
+		gap.QueuedDatetime = sql.NullTime{Valid: true, Time: time.Now()}
+		if err := gap.updateStatus(deps); err != nil {
+			sublog.Warn().Err(err).Msg("failed to mark gap queued")
+		}
+		queued++
+	}
+
+	sublog.Info().Int("gaps", len(gaps)).Int("queued", queued).Msg("found {gaps} gaps for {symbol}, queued {queued} backfills")
+	return nil
+}
+
+// findTickerDailyGaps groups the trading days from start through end that
+// aren't in existing into runs of consecutive missing sessions
+func findTickerDailyGaps(calendar *TradingCalendar, tickerId uint64, existing map[string]bool, start, end time.Time) []TickerDailyGap {
+	gaps := make([]TickerDailyGap, 0)
+
+	// walk whole days in the exchange's timezone, at noon so DST changes
+	// can't push us onto the wrong date
+	end = end.In(calendar.Location)
+	lastDay := time.Date(end.Year(), end.Month(), end.Day(), 12, 0, 0, 0, calendar.Location)
+	firstDay := time.Date(start.Year(), start.Month(), start.Day(), 12, 0, 0, 0, calendar.Location)
+
+	var current *TickerDailyGap
+	for day := firstDay; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		if !calendar.isTradingDay(day) {
+			continue
+		}
+		if existing[day.Format("2006-01-02")] {
+			if current != nil {
+				gaps = append(gaps, *current)
+				current = nil
+			}
+			continue
+		}
+		if current == nil {
+			current = &TickerDailyGap{TickerId: tickerId, GapStart: day, Status: "open"}
+		}
+		current.GapEnd = day
+		current.MissingDays++
+	}
+	if current != nil {
+		gaps = append(gaps, *current)
+	}
+	return gaps
+}
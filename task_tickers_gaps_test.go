@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindTickerDailyGaps(t *testing.T) {
+	// Thursday 2024-07-04 is Independence Day
+	start := time.Date(2024, 7, 1, 0, 0, 0, 0, newYork)
+	end := time.Date(2024, 7, 12, 18, 0, 0, 0, newYork)
+	existing := map[string]bool{
+		"2024-07-01": true, "2024-07-02": true,
+		"2024-07-08": true,
+		"2024-07-10": true, "2024-07-11": true, "2024-07-12": true,
+	}
+
+	gaps := findTickerDailyGaps(usCalendar, 1, existing, start, end)
+	want := []struct {
+		start, end string
+		missing    int
+	}{
+		{"2024-07-03", "2024-07-05", 2},
+		{"2024-07-09", "2024-07-09", 1},
+	}
+	if len(gaps) != len(want) {
+		t.Fatalf("got %d gaps, want %d: %+v", len(gaps), len(want), gaps)
+	}
+	for i, gap := range gaps {
+		if gap.GapStart.Format("2006-01-02") != want[i].start || gap.GapEnd.Format("2006-01-02") != want[i].end || gap.MissingDays != want[i].missing {
+			t.Errorf("gap %d: got %s to %s (%d), want %s to %s (%d)", i, gap.GapStart.Format("2006-01-02"), gap.GapEnd.Format("2006-01-02"), gap.MissingDays, want[i].start, want[i].end, want[i].missing)
+		}
+	}
+
+	if defaultCalendar.knowsHolidays() || !usCalendar.knowsHolidays() {
+		t.Errorf("only the US calendar knows its holidays")
+	}
+}
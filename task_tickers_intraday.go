@@ -1,14 +1,152 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 )
 
+var (
+	// intervals yhfinance supports for intraday bars, and how much history
+	// to request for each
+	intradayIntervalRanges = map[string]string{
+		"1m":  "1d",
+		"2m":  "1d",
+		"5m":  "1d",
+		"15m": "1d",
+		"30m": "1d",
+		"60m": "1d",
+		"90m": "1d",
+	}
+)
+
+type TaskTickerIntradayBody struct {
+	TaskTicker
+	TaskTickers
+	Interval string `json:"interval"`
+}
+
 func perform_tickers_intraday(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
-	if body != nil {
-		return false, fmt.Errorf("just testing")
+	if body == nil || *body == "" {
+		sublog.Error().Msg("missing task body for {action}")
+		return true, fmt.Errorf("missing task body")
+	}
+	var taskTickerIntradayBody TaskTickerIntradayBody
+	json.NewDecoder(strings.NewReader(*body)).Decode(&taskTickerIntradayBody)
+
+	interval := taskTickerIntradayBody.Interval
+	if interval == "" {
+		interval = defaultIntradayInterval
+	}
+	if _, ok := intradayIntervalRanges[interval]; !ok {
+		sublog.Error().Str("interval", interval).Msg("unsupported intraday interval {interval}")
+		return true, fmt.Errorf("unsupported intraday interval")
+	}
+
+	tickers, err := resolveTaskTickers(deps, sublog, taskTickerIntradayBody.TaskTicker, taskTickerIntradayBody.TaskTickers)
+	if err != nil {
+		return true, err
+	}
+
+	sublog = sublog.With().Str("interval", interval).Logger()
+	return performForTickers(deps, sublog, "intraday", tickers, taskTickerIntradayBody.TaskTickers,
+		func(sublog zerolog.Logger, ticker Ticker) error {
+			return perform_ticker_intraday(deps, sublog, ticker, interval)
+		},
+		func(retry TaskTickers) interface{} {
+			return TaskTickerIntradayBody{TaskTickers: retry, Interval: interval}
+		})
+}
+
+func perform_ticker_intraday(deps *Dependencies, sublog zerolog.Logger, ticker Ticker, interval string) error {
+	db := deps.db
+
+	sublog.Info().Msg("got task to possibly update {interval} intraday bars for {symbol}")
+
+	// skip calling API if we've succeeded at this recently, or since the
+	// market closed
+	calendar := getTradingCalendar(deps, ticker.ExchangeId)
+	lastdone := LastDone{Activity: "ticker_intraday", UniqueKey: ticker.TickerSymbol + "/" + interval, LastStatus: "failed"}
+	lastdone.getByActivity(db)
+	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.Add(minTickerIntradayDelay*time.Minute).After(time.Now()) {
+		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping {action} for {symbol}, recently received")
+		return nil
+	}
+	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && !calendar.isOpen(time.Now()) && lastdone.LastDoneDatetime.Time.After(calendar.lastSessionClose(time.Now())) {
+		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping {action} for {symbol}, market closed since last received")
+		return nil
+	}
+
+	// go get intraday pricing from yhfinance
+	sublog.Info().Msg("pulling intraday pricing {symbol} from yhfinance")
+	loadErr := loadTickerIntradayFromYH(deps, sublog, ticker, interval)
+	if loadErr == nil {
+		lastdone.LastStatus = "success"
+	} else {
+		lastdone.LastStatus = fmt.Sprintf("%e", loadErr)
+	}
+
+	lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
+
+	err := lastdone.createOrUpdate(db)
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to create or update lastdone for {symbol}")
+	}
+
+	pruneTickerIntraday(deps, sublog, ticker)
+
+	return loadErr
+}
+
+// load ticker intraday prices
+func loadTickerIntradayFromYH(deps *Dependencies, sublog zerolog.Logger, ticker Ticker, interval string) error {
+	chart, err := getYHChart(deps, ticker.TickerSymbol, map[string]string{"interval": interval, "range": intradayIntervalRanges[interval]})
+	if err != nil {
+		sublog.Warn().Err(err).Msg("failed to retrieve intraday prices")
+		return err
+	}
+
+	var lastErr error
+	for _, bar := range chart.Bars {
+		tickerIntraday := TickerIntraday{0, ticker.TickerId, interval, bar.Datetime, chart.Location.String(), bar.Open, bar.High, bar.Low, bar.Close, bar.Volume, time.Now(), time.Now()}
+		err = tickerIntraday.createOrUpdate(deps)
+		if err != nil {
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		sublog.Warn().Err(lastErr).Msg("failed to load at least one intraday price")
+	}
+	return lastErr
+}
+
+// pruneTickerIntraday drops bars older than the retention window, at most
+// once a day per ticker
+func pruneTickerIntraday(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) {
+	db := deps.db
+
+	lastdone := LastDone{Activity: "ticker_intraday_prune", UniqueKey: ticker.TickerSymbol, LastStatus: "failed"}
+	lastdone.getByActivity(db)
+	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.Add(minTickerIntradayPruneDelay*time.Minute).After(time.Now()) {
+		return
+	}
+
+	pruned, err := deleteTickerIntradayBefore(deps, ticker.TickerId, time.Now().Add(-intradayRetention*time.Minute))
+	if err == nil {
+		lastdone.LastStatus = "success"
+		sublog.Info().Int64("pruned", pruned).Msg("pruned {pruned} old intraday bars for {symbol}")
+	} else {
+		lastdone.LastStatus = fmt.Sprintf("%e", err)
+		sublog.Warn().Err(err).Msg("failed to prune old intraday bars for {symbol}")
+	}
+	lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
+
+	err = lastdone.createOrUpdate(db)
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to create or update lastdone for {symbol}")
 	}
-	return false, fmt.Errorf("just testing")
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/weirdtangent/yhfinance"
+)
+
+// the parts of yhfinance's stockProfile response we use
+type YHProfileResponse struct {
+	AssetProfile struct {
+		Address1 string `json:"address1"`
+		City     string `json:"city"`
+		State    string `json:"state"`
+		Zip      string `json:"zip"`
+		Country  string `json:"country"`
+		Phone    string `json:"phone"`
+		Website  string `json:"website"`
+		Industry string `json:"industry"`
+		Sector   string `json:"sector"`
+	} `json:"assetProfile"`
+	QuoteType struct {
+		LongName  string `json:"longName"`
+		ShortName string `json:"shortName"`
+	} `json:"quoteType"`
+}
+
+type TaskTickerProfileBody struct {
+	TaskTicker
+	TaskTickers
+}
+
+func perform_tickers_profile(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
+	if body == nil || *body == "" {
+		sublog.Error().Msg("missing task body for {action}")
+		return true, fmt.Errorf("missing task body")
+	}
+	var taskTickerProfileBody TaskTickerProfileBody
+	json.NewDecoder(strings.NewReader(*body)).Decode(&taskTickerProfileBody)
+
+	tickers, err := resolveTaskTickers(deps, sublog, taskTickerProfileBody.TaskTicker, taskTickerProfileBody.TaskTickers)
+	if err != nil {
+		return true, err
+	}
+
+	return performForTickers(deps, sublog, "profile", tickers, taskTickerProfileBody.TaskTickers,
+		func(sublog zerolog.Logger, ticker Ticker) error {
+			return perform_ticker_profile(deps, sublog, ticker)
+		},
+		func(retry TaskTickers) interface{} {
+			return TaskTickerProfileBody{TaskTickers: retry}
+		})
+}
+
+func perform_ticker_profile(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
+	db := deps.db
+
+	sublog.Info().Msg("got task to possibly update profile for {symbol}")
+
+	// skip calling API if we've succeeded at this recently
+	lastdone := LastDone{Activity: "ticker_profile", UniqueKey: ticker.TickerSymbol, LastStatus: "failed"}
+	lastdone.getByActivity(db)
+	if lastdone.LastStatus == "success" && lastdone.LastDoneDatetime.Valid && lastdone.LastDoneDatetime.Time.Add(minTickerProfileDelay*time.Minute).After(time.Now()) {
+		sublog.Info().Str("last_retrieved", lastdone.LastDoneDatetime.Time.Format(sqlDateTime)).Msg("skipping {action} for {symbol}, recently received")
+		return nil
+	}
+
+	// go get company profile from yhfinance
+	sublog.Info().Msg("pulling company profile for {symbol} from yhfinance")
+	loadErr := loadTickerProfileFromYH(deps, sublog, ticker)
+	if loadErr == nil {
+		lastdone.LastStatus = "success"
+	} else {
+		lastdone.LastStatus = fmt.Sprintf("%e", loadErr)
+	}
+
+	lastdone.LastDoneDatetime = sql.NullTime{Valid: true, Time: time.Now()}
+
+	err := lastdone.createOrUpdate(db)
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to create or update lastdone for {symbol}")
+	}
+
+	return loadErr
+}
+
+// load ticker company profile, only replacing fields we actually got a
+// value for so a sparse response doesn't blank out what we already have
+func loadTickerProfileFromYH(deps *Dependencies, sublog zerolog.Logger, ticker Ticker) error {
+	apiKey, apiHost := getYHSecrets(deps)
+
+	start := time.Now()
+	response, err := yhfinance.GetFromYHFinance(&sublog, apiKey, apiHost, "stockProfile", map[string]string{"symbol": ticker.TickerSymbol})
+	sublog.Info().Int64("response_time", time.Since(start).Nanoseconds()).Msg("timer: yhfinance stockProfile")
+	if err != nil {
+		sublog.Warn().Err(err).Msg("failed to retrieve company profile")
+		return err
+	}
+
+	var profileResponse YHProfileResponse
+	err = json.NewDecoder(strings.NewReader(response)).Decode(&profileResponse)
+	if err != nil {
+		return err
+	}
+
+	profile := profileResponse.AssetProfile
+	previousWebsite := ticker.Website
+
+	companyName := profileResponse.QuoteType.LongName
+	if companyName == "" {
+		companyName = profileResponse.QuoteType.ShortName
+	}
+	setIfNotEmpty(&ticker.CompanyName, companyName)
+	setIfNotEmpty(&ticker.Address, profile.Address1)
+	setIfNotEmpty(&ticker.City, profile.City)
+	setIfNotEmpty(&ticker.State, profile.State)
+	setIfNotEmpty(&ticker.Zip, profile.Zip)
+	setIfNotEmpty(&ticker.Country, profile.Country)
+	setIfNotEmpty(&ticker.Website, profile.Website)
+	setIfNotEmpty(&ticker.Phone, profile.Phone)
+	setIfNotEmpty(&ticker.Sector, profile.Sector)
+	setIfNotEmpty(&ticker.Industry, profile.Industry)
+
+	// a new website is worth another try at the favicon
+	if ticker.Website != previousWebsite && ticker.FavIconS3Key == "none" {
+		ticker.FavIconS3Key = ""
+	}
+
+	return ticker.Update(deps, sublog)
+}
+
+func setIfNotEmpty(field *string, value string) {
+	value = strings.TrimSpace(value)
+	if value != "" {
+		*field = value
+	}
+}
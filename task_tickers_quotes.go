@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/weirdtangent/yhfinance"
+)
+
+// the parts of yhfinance's marketQuotes response we use
+type YHQuotesResponse struct {
+	QuoteResponse struct {
+		Result []struct {
+			Symbol                     string  `json:"symbol"`
+			RegularMarketPrice         float64 `json:"regularMarketPrice"`
+			RegularMarketPreviousClose float64 `json:"regularMarketPreviousClose"`
+			RegularMarketVolume        int64   `json:"regularMarketVolume"`
+			RegularMarketTime          int64   `json:"regularMarketTime"`
+		} `json:"result"`
+	} `json:"quoteResponse"`
+}
+
+type TaskTickerQuotesBody struct {
+	TickerIds     []uint64 `json:"ticker_ids"`
+	TickerSymbols []string `json:"ticker_symbols"`
+	Watchlist     string   `json:"watchlist"`
+}
+
+func perform_tickers_quotes(deps *Dependencies, sublog zerolog.Logger, body *string) (bool, error) {
+	if body == nil || *body == "" {
+		sublog.Error().Msg("missing task body for {action}")
+		return true, fmt.Errorf("missing task body")
+	}
+	var taskTickerQuotesBody TaskTickerQuotesBody
+	json.NewDecoder(strings.NewReader(*body)).Decode(&taskTickerQuotesBody)
+
+	if len(taskTickerQuotesBody.TickerIds) == 0 && len(taskTickerQuotesBody.TickerSymbols) == 0 && taskTickerQuotesBody.Watchlist == "" {
+		sublog.Error().Msg("tickerIds, tickerSymbols OR watchlist must be provided")
+		return true, fmt.Errorf("tickerIds, tickerSymbols OR watchlist must be provided")
+	}
+
+	tickers, err := getTickersByIdsOrSymbols(deps, taskTickerQuotesBody.TickerIds, taskTickerQuotesBody.TickerSymbols)
+	if err != nil {
+		sublog.Error().Err(err).Msg("couldn't find tickers")
+		return true, err
+	}
+	if taskTickerQuotesBody.Watchlist != "" {
+		watchlistTickers, err := getTickersByWatchlist(deps, taskTickerQuotesBody.Watchlist)
+		if err != nil {
+			sublog.Error().Err(err).Str("watchlist", taskTickerQuotesBody.Watchlist).Msg("couldn't load watchlist {watchlist}")
+			return true, err
+		}
+		tickers = uniqueTickers(append(tickers, watchlistTickers...))
+	}
+	if len(tickers) == 0 {
+		sublog.Error().Msg("none of the tickers were found")
+		return true, fmt.Errorf("no tickers found")
+	}
+
+	// a closed market's quote won't change once we have one from after the close
+	now := time.Now()
+	openTickers := make([]Ticker, 0, len(tickers))
+	for _, ticker := range tickers {
+		calendar := getTradingCalendar(deps, ticker.ExchangeId)
+		if !calendar.isOpen(now) && !ticker.MarketPriceDatetime.Before(calendar.lastSessionClose(now)) {
+			continue
+		}
+		openTickers = append(openTickers, ticker)
+	}
+	if len(openTickers) == 0 {
+		sublog.Info().Msg("skipping {action}, all markets closed and quotes current")
+		return true, nil
+	}
+	tickers = openTickers
+
+	sublog = sublog.With().Int("tickers", len(tickers)).Logger()
+	sublog.Info().Msg("got task to update quotes for {tickers} tickers")
+
+	loaded, err := loadTickerQuotesFromYH(deps, sublog, tickers)
+	if err != nil {
+		sublog.Error().Err(err).Msg("failed to update quotes")
+	}
+	if loaded == 0 {
+		// nothing came back at all, leave it for another attempt
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// load current quotes for a list of tickers, as many symbols per call as
+// yhfinance allows, then update them all in one go; returns how many of the
+// calls succeeded
+func loadTickerQuotesFromYH(deps *Dependencies, sublog zerolog.Logger, tickers []Ticker) (int, error) {
+	apiKey, apiHost := getYHSecrets(deps)
+
+	tickersBySymbol := make(map[string]Ticker, len(tickers))
+	symbols := make([]string, 0, len(tickers))
+	for _, ticker := range tickers {
+		tickersBySymbol[ticker.TickerSymbol] = ticker
+		symbols = append(symbols, ticker.TickerSymbol)
+	}
+
+	var lastErr error
+	loaded := 0
+	quotes := make([]Ticker, 0, len(tickers))
+	for chunkStart := 0; chunkStart < len(symbols); chunkStart += maxQuotesPerRequest {
+		chunkEnd := chunkStart + maxQuotesPerRequest
+		if chunkEnd > len(symbols) {
+			chunkEnd = len(symbols)
+		}
+
+		start := time.Now()
+		response, err := yhfinance.GetFromYHFinance(&sublog, apiKey, apiHost, "marketQuotes", map[string]string{"symbols": strings.Join(symbols[chunkStart:chunkEnd], ",")})
+		sublog.Info().Int64("response_time", time.Since(start).Nanoseconds()).Msg("timer: yhfinance marketQuotes")
+		if err != nil {
+			sublog.Warn().Err(err).Msg("failed to retrieve quotes")
+			lastErr = err
+			continue
+		}
+
+		var quotesResponse YHQuotesResponse
+		err = json.NewDecoder(strings.NewReader(response)).Decode(&quotesResponse)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		loaded++
+
+		for _, quote := range quotesResponse.QuoteResponse.Result {
+			ticker, ok := tickersBySymbol[quote.Symbol]
+			if !ok || quote.RegularMarketTime == 0 {
+				continue
+			}
+			ticker.MarketPrice = quote.RegularMarketPrice
+			ticker.MarketPrevClose = quote.RegularMarketPreviousClose
+			ticker.MarketVolume = quote.RegularMarketVolume
+			ticker.MarketPriceDatetime = time.Unix(quote.RegularMarketTime, 0)
+			quotes = append(quotes, ticker)
+		}
+	}
+
+	sublog.Info().Int("quotes", len(quotes)).Msg("updating {quotes} ticker quotes")
+	if err := updateTickerQuotes(deps, quotes); err != nil {
+		return 0, err
+	}
+	return loaded, lastErr
+}
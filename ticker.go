@@ -3,8 +3,10 @@ package main
 import (
 	"database/sql"
 	"errors"
+	"math"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog"
 )
 
@@ -50,38 +52,85 @@ type Exchange struct {
 }
 
 type TickerDaily struct {
-	TickerDailyId  uint64 `db:"ticker_daily_id"`
-	EId            string
-	TickerId       uint64    `db:"ticker_id"`
-	PriceDatetime  time.Time `db:"price_datetime"`
-	OpenPrice      float64   `db:"open_price"`
-	HighPrice      float64   `db:"high_price"`
-	LowPrice       float64   `db:"low_price"`
-	ClosePrice     float64   `db:"close_price"`
-	Volume         int64     `db:"volume"`
-	CreateDatetime time.Time `db:"create_datetime"`
-	UpdateDatetime time.Time `db:"update_datetime"`
+	TickerDailyId uint64 `db:"ticker_daily_id"`
+	EId           string
+	TickerId      uint64    `db:"ticker_id"`
+	PriceDatetime time.Time `db:"price_datetime"`
+	OpenPrice     float64   `db:"open_price"`
+	HighPrice     float64   `db:"high_price"`
+	LowPrice      float64   `db:"low_price"`
+	ClosePrice    float64   `db:"close_price"`
+	Volume        int64     `db:"volume"`
+	// split-adjusted series, maintained by adjustTickerDailies
+	AdjOpenPrice  sql.NullFloat64 `db:"adj_open_price"`
+	AdjHighPrice  sql.NullFloat64 `db:"adj_high_price"`
+	AdjLowPrice   sql.NullFloat64 `db:"adj_low_price"`
+	AdjClosePrice sql.NullFloat64 `db:"adj_close_price"`
+	AdjVolume     sql.NullInt64   `db:"adj_volume"`
+	// multiply by AdjClosePrice for a total-return (dividends reinvested) close
+	TotalReturnFactor sql.NullFloat64 `db:"total_return_factor"`
+	CreateDatetime    time.Time       `db:"create_datetime"`
+	UpdateDatetime    time.Time       `db:"update_datetime"`
+}
+
+// intraday bars are stored with the exchange's local wall-clock time in
+// price_datetime, and the timezone it's in alongside
+type TickerIntraday struct {
+	TickerIntradayId uint64    `db:"ticker_intraday_id"`
+	TickerId         uint64    `db:"ticker_id"`
+	BarInterval      string    `db:"bar_interval"`
+	PriceDatetime    time.Time `db:"price_datetime"`
+	PriceTimezone    string    `db:"price_timezone"`
+	OpenPrice        float64   `db:"open_price"`
+	HighPrice        float64   `db:"high_price"`
+	LowPrice         float64   `db:"low_price"`
+	ClosePrice       float64   `db:"close_price"`
+	Volume           int64     `db:"volume"`
+	CreateDatetime   time.Time `db:"create_datetime"`
+	UpdateDatetime   time.Time `db:"update_datetime"`
+}
+
+// a run of consecutive trading days missing from ticker_daily; status goes
+// open => queued (backfill enqueued) => repaired
+type TickerDailyGap struct {
+	TickerDailyGapId uint64       `db:"ticker_daily_gap_id"`
+	TickerId         uint64       `db:"ticker_id"`
+	GapStart         time.Time    `db:"gap_start"`
+	GapEnd           time.Time    `db:"gap_end"`
+	MissingDays      int          `db:"missing_days"`
+	Status           string       `db:"status"`
+	Attempts         int          `db:"attempts"`
+	QueuedDatetime   sql.NullTime `db:"queued_datetime"`
+	DetectedDatetime time.Time    `db:"detected_datetime"`
+	CreateDatetime   time.Time    `db:"create_datetime"`
+	UpdateDatetime   time.Time    `db:"update_datetime"`
 }
 
 type TickerAttribute struct {
 	TickerAttributeId uint64 `db:"attribute_id"`
 	EId               string
-	TickerId          uint64    `db:"ticker_id"`
-	AttributeName     string    `db:"attribute_name"`
-	AttributeComment  string    `db:"attribute_comment"`
-	AttributeValue    string    `db:"attribute_value"`
-	CreateDatetime    time.Time `db:"create_datetime"`
-	UpdateDatetime    time.Time `db:"update_datetime"`
+	TickerId          uint64          `db:"ticker_id"`
+	AttributeName     string          `db:"attribute_name"`
+	AttributeComment  string          `db:"attribute_comment"`
+	AttributeValue    string          `db:"attribute_value"`
+	AttributeType     string          `db:"attribute_type"`
+	AttributeNumber   sql.NullFloat64 `db:"attribute_number"`
+	AttributeDate     sql.NullTime    `db:"attribute_date"`
+	CreateDatetime    time.Time       `db:"create_datetime"`
+	UpdateDatetime    time.Time       `db:"update_datetime"`
 }
 
 type TickerSplit struct {
-	TickerSplitId  uint64 `db:"ticker_split_id"`
-	EId            string
-	TickerId       uint64    `db:"ticker_id"`
-	SplitDate      time.Time `db:"split_date"`
-	SplitRatio     string    `db:"split_ratio"`
-	CreateDatetime time.Time `db:"create_datetime"`
-	UpdateDatetime time.Time `db:"update_datetime"`
+	TickerSplitId uint64 `db:"ticker_split_id"`
+	EId           string
+	TickerId      uint64    `db:"ticker_id"`
+	SplitDate     time.Time `db:"split_date"`
+	SplitRatio    string    `db:"split_ratio"`
+	// NULL for splits recorded before we stored factors, or with a ratio
+	// we couldn't parse
+	SplitFactor    sql.NullFloat64 `db:"split_factor"`
+	CreateDatetime time.Time       `db:"create_datetime"`
+	UpdateDatetime time.Time       `db:"update_datetime"`
 }
 
 func (t *Ticker) getById(deps *Dependencies) error {
@@ -91,6 +140,13 @@ func (t *Ticker) getById(deps *Dependencies) error {
 	return err
 }
 
+func (e *Exchange) getById(deps *Dependencies) error {
+	db := deps.db
+
+	err := db.QueryRowx("SELECT * FROM exchange WHERE exchange_id=?", e.ExchangeId).StructScan(e)
+	return err
+}
+
 func (t *Ticker) getBySymbol(deps *Dependencies) error {
 	db := deps.db
 
@@ -98,6 +154,93 @@ func (t *Ticker) getBySymbol(deps *Dependencies) error {
 	return err
 }
 
+// getTickersByIdsOrSymbols looks up every ticker matching either list, each
+// once even if it's in both; unknown ids or symbols are just left out
+func getTickersByIdsOrSymbols(deps *Dependencies, tickerIds []uint64, tickerSymbols []string) ([]Ticker, error) {
+	db := deps.db
+
+	tickers := make([]Ticker, 0, len(tickerIds)+len(tickerSymbols))
+	if len(tickerIds) > 0 {
+		query, args, err := sqlx.In("SELECT * FROM ticker WHERE ticker_id IN (?)", tickerIds)
+		if err != nil {
+			return nil, err
+		}
+		var found []Ticker
+		if err := db.Select(&found, db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		tickers = append(tickers, found...)
+	}
+	if len(tickerSymbols) > 0 {
+		query, args, err := sqlx.In("SELECT * FROM ticker WHERE ticker_symbol IN (?)", tickerSymbols)
+		if err != nil {
+			return nil, err
+		}
+		var found []Ticker
+		if err := db.Select(&found, db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		tickers = append(tickers, found...)
+	}
+	return uniqueTickers(tickers), nil
+}
+
+// uniqueTickers drops repeats of a ticker, keeping the first
+func uniqueTickers(tickers []Ticker) []Ticker {
+	seen := make(map[uint64]bool, len(tickers))
+	unique := tickers[:0]
+	for _, ticker := range tickers {
+		if !seen[ticker.TickerId] {
+			seen[ticker.TickerId] = true
+			unique = append(unique, ticker)
+		}
+	}
+	return unique
+}
+
+// getTickersByWatchlist returns every ticker on the named watchlist
+func getTickersByWatchlist(deps *Dependencies, watchlistName string) ([]Ticker, error) {
+	db := deps.db
+
+	var tickers []Ticker
+	var query = `SELECT ticker.* FROM watchlist
+		JOIN watchlist_ticker ON (watchlist_ticker.watchlist_id=watchlist.watchlist_id)
+		JOIN ticker ON (ticker.ticker_id=watchlist_ticker.ticker_id)
+		WHERE watchlist.watchlist_name=?`
+	err := db.Select(&tickers, query, watchlistName)
+	return tickers, err
+}
+
+// updateTickerQuotes writes the market price fields for a batch of tickers
+// in a single transaction
+func updateTickerQuotes(deps *Dependencies, tickers []Ticker) error {
+	db := deps.db
+
+	if len(tickers) == 0 {
+		return nil
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	update, err := tx.Prepare("UPDATE ticker SET market_price=?, market_prev_close=?, market_volume=?, market_price_datetime=? WHERE ticker_id=?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer update.Close()
+
+	for _, t := range tickers {
+		_, err = update.Exec(t.MarketPrice, t.MarketPrevClose, t.MarketVolume, t.MarketPriceDatetime, t.TickerId)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func updateTickerPerformanceId(deps *Dependencies, tickerId uint64, performanceId string) error {
 	db := deps.db
 	sublog := deps.logger
@@ -117,16 +260,19 @@ func updateTickerPerformanceId(deps *Dependencies, tickerId uint64, performanceI
 func (t *Ticker) createOrUpdateAttribute(deps *Dependencies, attributeName, attributeComment, attributeValue string) error {
 	db := deps.db
 
-	attribute := TickerAttribute{0, "", t.TickerId, attributeName, "", attributeValue, time.Now(), time.Now()}
+	// keep a typed copy of the value so tickers can be sorted/filtered on it
+	sv := parseStatisticValue(attributeValue)
+
+	attribute := TickerAttribute{0, "", t.TickerId, attributeName, attributeComment, attributeValue, sv.Kind, sv.Number, sv.Date, time.Now(), time.Now()}
 	err := attribute.getByUniqueKey(deps)
 	if err == nil {
-		var update = "UPDATE ticker_attribute SET attribute_value=? WHERE ticker_id=? AND attribute_name=? AND attribute_comment=?"
-		db.Exec(update, attributeValue, t.TickerId, attributeName, attributeComment)
+		var update = "UPDATE ticker_attribute SET attribute_value=?, attribute_type=?, attribute_number=?, attribute_date=? WHERE ticker_id=? AND attribute_name=? AND attribute_comment=?"
+		db.Exec(update, attributeValue, sv.Kind, sv.Number, sv.Date, t.TickerId, attributeName, attributeComment)
 		return nil
 	}
 
-	var insert = "INSERT INTO ticker_attribute SET ticker_id=?, attribute_name=?, attribute_value=?, attribute_comment=?"
-	db.Exec(insert, t.TickerId, attributeName, attributeValue, attributeComment)
+	var insert = "INSERT INTO ticker_attribute SET ticker_id=?, attribute_name=?, attribute_value=?, attribute_comment=?, attribute_type=?, attribute_number=?, attribute_date=?"
+	db.Exec(insert, t.TickerId, attributeName, attributeValue, attributeComment, sv.Kind, sv.Number, sv.Date)
 	return nil
 }
 
@@ -197,12 +343,45 @@ func (t *Ticker) createOrUpdate(deps *Dependencies) error {
 	return t.getById(deps)
 }
 
-func (td *TickerDaily) checkByDate(deps *Dependencies) uint64 {
+func (td *TickerDaily) getByDate(deps *Dependencies) error {
 	db := deps.db
 
-	var tickerDailyId uint64
-	db.QueryRowx(`SELECT ticker_daily_id FROM ticker_daily WHERE ticker_id=? AND price_date LIKE ?`, td.TickerId, td.PriceDatetime.Format("2006-01-02%")).Scan(&tickerDailyId)
-	return tickerDailyId
+	err := db.QueryRowx(`SELECT ticker_daily_id, ticker_id, price_datetime, open_price, high_price, low_price, close_price, volume FROM ticker_daily WHERE ticker_id=? AND price_date LIKE ?`, td.TickerId, td.PriceDatetime.Format("2006-01-02%")).StructScan(td)
+	return err
+}
+
+// tickerDailyChanged reports whether a bar we got differs from the one we
+// stored for the day; prices closer than the column keeps are the same
+func tickerDailyChanged(stored, tickerDaily TickerDaily) bool {
+	prices := [][2]float64{
+		{stored.OpenPrice, tickerDaily.OpenPrice},
+		{stored.HighPrice, tickerDaily.HighPrice},
+		{stored.LowPrice, tickerDaily.LowPrice},
+		{stored.ClosePrice, tickerDaily.ClosePrice},
+	}
+	for _, price := range prices {
+		if math.Abs(price[0]-price[1]) >= 0.00005 {
+			return true
+		}
+	}
+	return stored.Volume != tickerDaily.Volume
+}
+
+// getTickerDailyDates returns the days from start through end we already
+// have a price for
+func getTickerDailyDates(deps *Dependencies, tickerId uint64, start, end time.Time) (map[string]bool, error) {
+	db := deps.db
+
+	var dates []string
+	err := db.Select(&dates, "SELECT DATE_FORMAT(price_date, '%Y-%m-%d') FROM ticker_daily WHERE ticker_id=? AND price_date BETWEEN ? AND ?", tickerId, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(dates))
+	for _, date := range dates {
+		existing[date] = true
+	}
+	return existing, nil
 }
 
 func (td *TickerDaily) create(deps *Dependencies) error {
@@ -231,19 +410,41 @@ func (td *TickerDaily) createOrUpdate(deps *Dependencies) error {
 		return nil
 	}
 
-	td.TickerDailyId = td.checkByDate(deps)
-	if td.TickerDailyId == 0 {
+	stored := TickerDaily{TickerId: td.TickerId, PriceDatetime: td.PriceDatetime}
+	err := stored.getByDate(deps)
+	if err == sql.ErrNoRows {
 		return td.create(deps)
 	}
+	if err != nil {
+		sublog.Warn().Err(err).Msg("failed on SELECT")
+		return err
+	}
+	td.TickerDailyId = stored.TickerDailyId
+	if !tickerDailyChanged(stored, *td) {
+		// leave the adjusted series alone, so indicators carry on from it
+		return nil
+	}
 
-	var update = "UPDATE ticker_daily SET price_datetime=?, open_price=?, high_price=?, low_price=?, close_price=?, volume=? WHERE ticker_id=? AND price_date LIKE ?"
-	_, err := db.Exec(update, td.PriceDatetime, td.OpenPrice, td.HighPrice, td.LowPrice, td.ClosePrice, td.Volume, td.TickerId, td.PriceDatetime.Format("2006-01-02%"))
+	// clearing the adjusted series makes the next adjustTickerDailies pass recompute it
+	var update = "UPDATE ticker_daily SET price_datetime=?, open_price=?, high_price=?, low_price=?, close_price=?, volume=?, adj_open_price=NULL, adj_high_price=NULL, adj_low_price=NULL, adj_close_price=NULL, adj_volume=NULL, total_return_factor=NULL WHERE ticker_id=? AND price_date LIKE ?"
+	_, err = db.Exec(update, td.PriceDatetime, td.OpenPrice, td.HighPrice, td.LowPrice, td.ClosePrice, td.Volume, td.TickerId, td.PriceDatetime.Format("2006-01-02%"))
 	if err != nil {
 		sublog.Warn().Err(err).Msg("failed on UPDATE")
 	}
 	return err
 }
 
+type TickerDividend struct {
+	TickerDividendId uint64 `db:"ticker_dividend_id"`
+	EId              string
+	TickerId         uint64    `db:"ticker_id"`
+	ExDate           time.Time `db:"ex_date"`
+	Amount           float64   `db:"amount"`
+	Currency         string    `db:"currency"`
+	CreateDatetime   time.Time `db:"create_datetime"`
+	UpdateDatetime   time.Time `db:"update_datetime"`
+}
+
 func (ts *TickerSplit) getByDate(deps *Dependencies) error {
 	db := deps.db
 
@@ -251,24 +452,276 @@ func (ts *TickerSplit) getByDate(deps *Dependencies) error {
 	return err
 }
 
-func (ts *TickerSplit) createIfNew(deps *Dependencies) error {
+// createIfNew returns true if the split wasn't already known, meaning the
+// adjusted price series needs to be recomputed
+func (ts *TickerSplit) createIfNew(deps *Dependencies) (bool, error) {
 	db := deps.db
 	sublog := deps.logger
 
 	if ts.SplitRatio == "" {
 		// Refusing to add ticker split with blank ratio
-		return nil
+		return false, nil
 	}
 
 	err := ts.getByDate(deps)
 	if err == nil {
-		return nil
+		return false, nil
 	}
 
-	var insert = "INSERT INTO ticker_split SET ticker_id=?, split_date=?, split_ratio=?"
-	_, err = db.Exec(insert, ts.TickerId, ts.SplitDate, ts.SplitRatio)
+	factor, err := parseSplitRatio(ts.SplitRatio)
+	if err != nil {
+		sublog.Warn().Err(err).Str("split_ratio", ts.SplitRatio).Msg("unparseable split ratio, split will not be applied")
+	}
+	ts.SplitFactor = sql.NullFloat64{Valid: err == nil, Float64: factor}
+
+	var insert = "INSERT INTO ticker_split SET ticker_id=?, split_date=?, split_ratio=?, split_factor=?"
+	_, err = db.Exec(insert, ts.TickerId, ts.SplitDate, ts.SplitRatio, ts.SplitFactor)
+	if err != nil {
+		sublog.Fatal().Err(err).Msg("failed on INSERT")
+	}
+	return true, err
+}
+
+func (td *TickerDividend) getByDate(deps *Dependencies) error {
+	db := deps.db
+
+	err := db.QueryRowx(`SELECT * FROM ticker_dividend WHERE ticker_id=? AND ex_date=?`, td.TickerId, td.ExDate).StructScan(td)
+	return err
+}
+
+// createIfNew returns true if the dividend wasn't already known, meaning the
+// total-return factors need to be recomputed
+func (td *TickerDividend) createIfNew(deps *Dependencies) (bool, error) {
+	db := deps.db
+	sublog := deps.logger
+
+	if td.Amount <= 0 {
+		// Refusing to add ticker dividend with no amount
+		return false, nil
+	}
+
+	err := td.getByDate(deps)
+	if err == nil {
+		return false, nil
+	}
+
+	var insert = "INSERT INTO ticker_dividend SET ticker_id=?, ex_date=?, amount=?, currency=?"
+	_, err = db.Exec(insert, td.TickerId, td.ExDate, td.Amount, td.Currency)
 	if err != nil {
 		sublog.Fatal().Err(err).Msg("failed on INSERT")
 	}
+	return true, err
+}
+
+func (ti *TickerIntraday) createOrUpdate(deps *Dependencies) error {
+	db := deps.db
+	sublog := deps.logger
+
+	// format it ourselves, the driver would convert it to the connection's
+	// timezone and we want the exchange-local time
+	localDatetime := ti.PriceDatetime.Format(sqlDateTime)
+
+	var insertOrUpdate = "INSERT INTO ticker_intraday SET ticker_id=?, bar_interval=?, price_datetime=?, price_timezone=?, open_price=?, high_price=?, low_price=?, close_price=?, volume=? ON DUPLICATE KEY UPDATE open_price=?, high_price=?, low_price=?, close_price=?, volume=?"
+	_, err := db.Exec(insertOrUpdate, ti.TickerId, ti.BarInterval, localDatetime, ti.PriceTimezone, ti.OpenPrice, ti.HighPrice, ti.LowPrice, ti.ClosePrice, ti.Volume, ti.OpenPrice, ti.HighPrice, ti.LowPrice, ti.ClosePrice, ti.Volume)
+	if err != nil {
+		sublog.Warn().Err(err).Str("table_name", "ticker_intraday").Msg("failed on INSERT OR UPDATE")
+	}
+	return err
+}
+
+// deleteTickerIntradayBefore deletes a ticker's bars from before a moment.
+// price_datetime is exchange-local, so the cutoff is put into each timezone
+// the ticker's bars are stored in rather than compared as server time.
+func deleteTickerIntradayBefore(deps *Dependencies, tickerId uint64, before time.Time) (int64, error) {
+	db := deps.db
+
+	var timezones []string
+	err := db.Select(&timezones, "SELECT DISTINCT price_timezone FROM ticker_intraday WHERE ticker_id=?", tickerId)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, timezone := range timezones {
+		// an empty name, from bars stored before one was required, loads
+		// as UTC, which is off by hours at most and fine for pruning
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return deleted, err
+		}
+		res, err := db.Exec("DELETE FROM ticker_intraday WHERE ticker_id=? AND price_timezone=? AND price_datetime < ?", tickerId, timezone, before.In(location).Format(sqlDateTime))
+		if err != nil {
+			return deleted, err
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += count
+	}
+	return deleted, nil
+}
+
+// getTickerDailyFirstDate returns the oldest day we have a price for, or a
+// zero time if there are none
+func getTickerDailyFirstDate(deps *Dependencies, tickerId uint64) (time.Time, error) {
+	db := deps.db
+
+	var firstDate sql.NullString
+	err := db.QueryRowx("SELECT DATE_FORMAT(MIN(price_date), '%Y-%m-%d') FROM ticker_daily WHERE ticker_id=?", tickerId).Scan(&firstDate)
+	if err != nil || !firstDate.Valid {
+		return time.Time{}, err
+	}
+	return time.Parse("2006-01-02", firstDate.String)
+}
+
+// getOpenTickerDailyGaps returns the gaps for a ticker not yet repaired
+func getOpenTickerDailyGaps(deps *Dependencies, tickerId uint64) ([]TickerDailyGap, error) {
+	db := deps.db
+
+	var gaps []TickerDailyGap
+	err := db.Select(&gaps, "SELECT * FROM ticker_daily_gap WHERE ticker_id=? AND status != 'repaired'", tickerId)
+	return gaps, err
+}
+
+func (tdg *TickerDailyGap) getByStart(deps *Dependencies) error {
+	db := deps.db
+
+	err := db.QueryRowx("SELECT * FROM ticker_daily_gap WHERE ticker_id=? AND gap_start=?", tdg.TickerId, tdg.GapStart.Format("2006-01-02")).StructScan(tdg)
+	return err
+}
+
+// createOrUpdate records a gap, or refreshes its extent if we already knew
+// about it; either way tdg ends up with the stored status and attempts
+func (tdg *TickerDailyGap) createOrUpdate(deps *Dependencies) error {
+	db := deps.db
+
+	gapEnd := tdg.GapEnd
+	missingDays := tdg.MissingDays
+
+	err := tdg.getByStart(deps)
+	if err == nil {
+		if tdg.Status == "repaired" {
+			// it's missing again
+			tdg.Status = "open"
+		}
+		tdg.GapEnd = gapEnd
+		tdg.MissingDays = missingDays
+		var update = "UPDATE ticker_daily_gap SET gap_end=?, missing_days=?, status=? WHERE ticker_daily_gap_id=?"
+		_, err = db.Exec(update, tdg.GapEnd.Format("2006-01-02"), tdg.MissingDays, tdg.Status, tdg.TickerDailyGapId)
+		return err
+	}
+
+	var insert = "INSERT INTO ticker_daily_gap SET ticker_id=?, gap_start=?, gap_end=?, missing_days=?, status=?, attempts=0, detected_datetime=now()"
+	res, err := db.Exec(insert, tdg.TickerId, tdg.GapStart.Format("2006-01-02"), tdg.GapEnd.Format("2006-01-02"), tdg.MissingDays, tdg.Status)
+	if err != nil {
+		return err
+	}
+	recordId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	tdg.TickerDailyGapId = uint64(recordId)
+	return nil
+}
+
+func (tdg *TickerDailyGap) updateStatus(deps *Dependencies) error {
+	db := deps.db
+
+	var update = "UPDATE ticker_daily_gap SET status=?, attempts=?, queued_datetime=? WHERE ticker_daily_gap_id=?"
+	_, err := db.Exec(update, tdg.Status, tdg.Attempts, tdg.QueuedDatetime, tdg.TickerDailyGapId)
+	return err
+}
+
+// TickerEarnings is one fiscal quarter's earnings report for a ticker: when
+// it's (or was) reported, what was expected and what actually came in
+type TickerEarnings struct {
+	TickerEarningsId uint64          `db:"ticker_earnings_id"`
+	TickerId         uint64          `db:"ticker_id"`
+	FiscalQuarter    time.Time       `db:"fiscal_quarter"`
+	EarningsDate     sql.NullTime    `db:"earnings_date"`
+	EpsEstimate      sql.NullFloat64 `db:"eps_estimate"`
+	EpsActual        sql.NullFloat64 `db:"eps_actual"`
+	RevenueEstimate  sql.NullFloat64 `db:"revenue_estimate"`
+	SurprisePercent  sql.NullFloat64 `db:"surprise_percent"`
+	CreateDatetime   time.Time       `db:"create_datetime"`
+	UpdateDatetime   time.Time       `db:"update_datetime"`
+	// FiscalQuarter was worked out by us, not reported by the provider
+	quarterGuessed bool
+}
+
+// getByQuarter finds the row for the quarter ending closest to
+// te.FiscalQuarter, within earningsQuarterSlack days: the quarter end we
+// guess for an upcoming report can be a few days off the one the provider
+// reports later (52/53-week fiscal years end on a weekday, not month-end)
+func (te *TickerEarnings) getByQuarter(deps *Dependencies) error {
+	db := deps.db
+
+	quarter := te.FiscalQuarter.Format("2006-01-02")
+	from := te.FiscalQuarter.AddDate(0, 0, -earningsQuarterSlack).Format("2006-01-02")
+	until := te.FiscalQuarter.AddDate(0, 0, earningsQuarterSlack).Format("2006-01-02")
+	err := db.QueryRowx("SELECT * FROM ticker_earnings WHERE ticker_id=? AND fiscal_quarter BETWEEN ? AND ? ORDER BY ABS(DATEDIFF(fiscal_quarter, ?)) LIMIT 1", te.TickerId, from, until, quarter).StructScan(te)
 	return err
 }
+
+// createOrUpdate records a quarter, keeping anything we already had that
+// this update doesn't include (the report date only shows up while the
+// report is upcoming, the actuals only once it's out). A reported quarter
+// end replaces a guessed one.
+func (te *TickerEarnings) createOrUpdate(deps *Dependencies) error {
+	db := deps.db
+
+	existing := TickerEarnings{TickerId: te.TickerId, FiscalQuarter: te.FiscalQuarter}
+	err := existing.getByQuarter(deps)
+	if err == nil {
+		te.TickerEarningsId = existing.TickerEarningsId
+		if te.quarterGuessed {
+			te.FiscalQuarter = existing.FiscalQuarter
+		}
+		if !te.EarningsDate.Valid {
+			te.EarningsDate = existing.EarningsDate
+		}
+		if !te.EpsEstimate.Valid {
+			te.EpsEstimate = existing.EpsEstimate
+		}
+		if !te.EpsActual.Valid {
+			te.EpsActual = existing.EpsActual
+		}
+		if !te.RevenueEstimate.Valid {
+			te.RevenueEstimate = existing.RevenueEstimate
+		}
+	}
+	te.SurprisePercent = earningsSurprisePercent(te.EpsActual, te.EpsEstimate)
+
+	var earningsDate interface{}
+	if te.EarningsDate.Valid {
+		earningsDate = te.EarningsDate.Time.Format("2006-01-02")
+	}
+
+	if te.TickerEarningsId > 0 {
+		var update = "UPDATE ticker_earnings SET fiscal_quarter=?, earnings_date=?, eps_estimate=?, eps_actual=?, revenue_estimate=?, surprise_percent=? WHERE ticker_earnings_id=?"
+		_, err = db.Exec(update, te.FiscalQuarter.Format("2006-01-02"), earningsDate, te.EpsEstimate, te.EpsActual, te.RevenueEstimate, te.SurprisePercent, te.TickerEarningsId)
+		return err
+	}
+
+	var insert = "INSERT INTO ticker_earnings SET ticker_id=?, fiscal_quarter=?, earnings_date=?, eps_estimate=?, eps_actual=?, revenue_estimate=?, surprise_percent=?"
+	res, err := db.Exec(insert, te.TickerId, te.FiscalQuarter.Format("2006-01-02"), earningsDate, te.EpsEstimate, te.EpsActual, te.RevenueEstimate, te.SurprisePercent)
+	if err != nil {
+		return err
+	}
+	recordId, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	te.TickerEarningsId = uint64(recordId)
+	return nil
+}
+
+// earningsSurprisePercent is how far the actual EPS came in above (or below)
+// the estimate, as a percentage of the estimate
+func earningsSurprisePercent(actual, estimate sql.NullFloat64) sql.NullFloat64 {
+	if !actual.Valid || !estimate.Valid || estimate.Float64 == 0 {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Valid: true, Float64: (actual.Float64 - estimate.Float64) / math.Abs(estimate.Float64) * 100}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUniqueTickers(t *testing.T) {
+	tickers := []Ticker{{TickerId: 1, TickerSymbol: "AAPL"}, {TickerId: 2, TickerSymbol: "MSFT"}, {TickerId: 1, TickerSymbol: "AAPL"}, {TickerId: 3, TickerSymbol: "GOOG"}, {TickerId: 2, TickerSymbol: "MSFT"}}
+	want := []Ticker{{TickerId: 1, TickerSymbol: "AAPL"}, {TickerId: 2, TickerSymbol: "MSFT"}, {TickerId: 3, TickerSymbol: "GOOG"}}
+	if got := uniqueTickers(tickers); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := uniqueTickers(nil); len(got) != 0 {
+		t.Errorf("got %v from nothing", got)
+	}
+}
+
+// re-storing the bar we already have mustn't clear its adjusted prices, or
+// every eods run would recompute the ticker's indicators from scratch
+func TestTickerDailyChanged(t *testing.T) {
+	stored := dailyBar(4, 10, 11, 9, 10.5)
+	tests := []struct {
+		name string
+		bar  TickerDaily
+		want bool
+	}{
+		{"identical", dailyBar(4, 10, 11, 9, 10.5), false},
+		{"rounded the same", dailyBar(4, 10.00001, 11, 9, 10.49999), false},
+		{"corrected close", dailyBar(4, 10, 11, 9, 10.6), true},
+		{"corrected low", dailyBar(4, 10, 11, 8.9, 10.5), true},
+		{"corrected volume", TickerDaily{OpenPrice: 10, HighPrice: 11, LowPrice: 9, ClosePrice: 10.5, Volume: 2000}, true},
+	}
+	for _, test := range tests {
+		if got := tickerDailyChanged(stored, test.bar); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
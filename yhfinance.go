@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/weirdtangent/yhfinance"
+)
+
+// the parts of yhfinance's stockChart response we use; values come back as
+// parallel arrays which can have nulls in them for missing bars
+type YHChartResponse struct {
+	Chart struct {
+		Result []struct {
+			Meta struct {
+				Symbol               string `json:"symbol"`
+				ExchangeTimezoneName string `json:"exchangeTimezoneName"`
+				GMTOffset            int    `json:"gmtoffset"`
+				Currency             string `json:"currency"`
+			} `json:"meta"`
+			Timestamp []int64 `json:"timestamp"`
+			// only there when asked for with events=div,split, keyed by
+			// timestamp
+			Events struct {
+				Dividends map[string]struct {
+					Date   int64   `json:"date"`
+					Amount float64 `json:"amount"`
+				} `json:"dividends"`
+				Splits map[string]struct {
+					Date       int64  `json:"date"`
+					SplitRatio string `json:"splitRatio"`
+				} `json:"splits"`
+			} `json:"events"`
+			Indicators struct {
+				Quote []struct {
+					Open   []*float64 `json:"open"`
+					High   []*float64 `json:"high"`
+					Low    []*float64 `json:"low"`
+					Close  []*float64 `json:"close"`
+					Volume []*int64   `json:"volume"`
+				} `json:"quote"`
+			} `json:"indicators"`
+		} `json:"result"`
+		Error *struct {
+			Code        string `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	} `json:"chart"`
+}
+
+// YHChartBar is one complete bar out of a YHChartResponse, with its
+// timestamp in the exchange's own timezone
+type YHChartBar struct {
+	Datetime time.Time
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   int64
+}
+
+// YHChart is what getYHChart pulls out of a YHChartResponse; splits and
+// dividends are only filled in if they were asked for, oldest first
+type YHChart struct {
+	Bars      []YHChartBar
+	Location  *time.Location
+	Splits    []YHChartSplit
+	Dividends []YHChartDividend
+}
+
+type YHChartSplit struct {
+	Date       time.Time
+	SplitRatio string
+}
+
+type YHChartDividend struct {
+	Date     time.Time
+	Amount   float64
+	Currency string
+}
+
+func getYHSecrets(deps *Dependencies) (string, string) {
+	secrets := deps.secrets
+	sublog := deps.logger
+
+	apiKey := secrets["yhfinance_rapidapi_key"]
+	apiHost := secrets["yhfinance_rapidapi_host"]
+	if apiKey == "" || apiHost == "" {
+		sublog.Fatal().Msg("apiKey or apiHost secret is missing")
+	}
+	return apiKey, apiHost
+}
+
+// getYHChart pulls OHLCV bars for one symbol; params are passed on as-is
+// (interval, range or period1/period2, events). Bars with any missing value
+// are dropped, and the exchange's timezone is returned along with them.
+func getYHChart(deps *Dependencies, symbol string, params map[string]string) (YHChart, error) {
+	sublog := deps.logger
+
+	apiKey, apiHost := getYHSecrets(deps)
+
+	chartParams := map[string]string{"symbol": symbol}
+	for key, value := range params {
+		chartParams[key] = value
+	}
+
+	start := time.Now()
+	response, err := yhfinance.GetFromYHFinance(sublog, apiKey, apiHost, "stockChart", chartParams)
+	sublog.Info().Int64("response_time", time.Since(start).Nanoseconds()).Msg("timer: yhfinance stockChart")
+	if err != nil {
+		return YHChart{}, err
+	}
+
+	var chartResponse YHChartResponse
+	err = json.NewDecoder(strings.NewReader(response)).Decode(&chartResponse)
+	if err != nil {
+		return YHChart{}, err
+	}
+	return chartFromYH(symbol, chartResponse)
+}
+
+func chartFromYH(symbol string, chartResponse YHChartResponse) (YHChart, error) {
+	if chartResponse.Chart.Error != nil {
+		return YHChart{}, fmt.Errorf("stockChart error: %s", chartResponse.Chart.Error.Description)
+	}
+	if len(chartResponse.Chart.Result) == 0 || len(chartResponse.Chart.Result[0].Indicators.Quote) == 0 {
+		return YHChart{Location: time.UTC}, nil
+	}
+
+	result := chartResponse.Chart.Result[0]
+	// bars are stored in exchange-local time by timezone name, so one we
+	// can't load isn't worth guessing at with a bare offset
+	if result.Meta.ExchangeTimezoneName == "" {
+		return YHChart{}, fmt.Errorf("stockChart: no exchange timezone for %s", symbol)
+	}
+	location, err := time.LoadLocation(result.Meta.ExchangeTimezoneName)
+	if err != nil {
+		return YHChart{}, fmt.Errorf("stockChart: exchange timezone for %s: %w", symbol, err)
+	}
+	chart := YHChart{Location: location}
+
+	quote := result.Indicators.Quote[0]
+	chart.Bars = make([]YHChartBar, 0, len(result.Timestamp))
+	for i, timestamp := range result.Timestamp {
+		if i >= len(quote.Open) || i >= len(quote.High) || i >= len(quote.Low) || i >= len(quote.Close) || i >= len(quote.Volume) {
+			break
+		}
+		if quote.Open[i] == nil || quote.High[i] == nil || quote.Low[i] == nil || quote.Close[i] == nil || quote.Volume[i] == nil {
+			continue
+		}
+		chart.Bars = append(chart.Bars, YHChartBar{time.Unix(timestamp, 0).In(location), *quote.Open[i], *quote.High[i], *quote.Low[i], *quote.Close[i], *quote.Volume[i]})
+	}
+
+	for _, split := range result.Events.Splits {
+		chart.Splits = append(chart.Splits, YHChartSplit{time.Unix(split.Date, 0).In(location), split.SplitRatio})
+	}
+	sort.Slice(chart.Splits, func(i, j int) bool { return chart.Splits[i].Date.Before(chart.Splits[j].Date) })
+	currency := result.Meta.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+	for _, dividend := range result.Events.Dividends {
+		chart.Dividends = append(chart.Dividends, YHChartDividend{time.Unix(dividend.Date, 0).In(location), dividend.Amount, currency})
+	}
+	sort.Slice(chart.Dividends, func(i, j int) bool { return chart.Dividends[i].Date.Before(chart.Dividends[j].Date) })
+	return chart, nil
+}
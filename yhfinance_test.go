@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestChartFromYH(t *testing.T) {
+	file, err := os.Open("testdata/chart.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var chartResponse YHChartResponse
+	if err := json.NewDecoder(file).Decode(&chartResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	chart, err := chartFromYH("ACME", chartResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chart.Location.String() != "America/New_York" {
+		t.Errorf("got location %s", chart.Location)
+	}
+	// the bar with a missing open is dropped
+	if len(chart.Bars) != 2 || chart.Bars[0].Datetime.Format(sqlDateTime) != "2024-06-05 09:30:00" || chart.Bars[1].Close != 10.3 {
+		t.Errorf("got bars %+v", chart.Bars)
+	}
+	if len(chart.Splits) != 1 || chart.Splits[0].SplitRatio != "10:1" || chart.Splits[0].Date.Format("2006-01-02") != "2024-06-07" {
+		t.Errorf("got splits %+v", chart.Splits)
+	}
+	if len(chart.Dividends) != 1 || chart.Dividends[0].Amount != 0.25 || chart.Dividends[0].Currency != "USD" || chart.Dividends[0].Date.Format("2006-01-02") != "2024-06-06" {
+		t.Errorf("got dividends %+v", chart.Dividends)
+	}
+
+	chartResponse.Chart.Result[0].Meta.ExchangeTimezoneName = ""
+	if _, err := chartFromYH("ACME", chartResponse); err == nil {
+		t.Error("no error without an exchange timezone")
+	}
+}